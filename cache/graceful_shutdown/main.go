@@ -3,6 +3,7 @@ package graceful_shutdown
 import (
 	"context"
 	"github.com/dongma/imola/cache/graceful_shutdown/service"
+	"github.com/dongma/imola/web"
 	"log"
 	"net/http"
 	"time"
@@ -15,7 +16,27 @@ func main() {
 		_, _ = writer.Write([]byte("hello"))
 	}))
 	s2 := service.NewServer("admin", "localhost:8081")
-	app := service.NewApp([]*service.Server{s1, s2}, service.WithShutdownCallbacks(StoreCacheToDBCallback))
+	webServer := web.NewHTTPServer()
+	webServer.GET("/hello", func(ctx *web.Context) {
+		ctx.RespData = []byte("hello")
+	})
+	// 后台任务，收到退出信号之后ctx会被取消
+	worker := service.NewWorker("refresher", func(ctx context.Context) {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				log.Printf("刷新配置中....")
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+	// 关闭顺序：business、admin、web，最后是后台任务
+	app := service.NewApp([]*service.Server{s1, s2},
+		service.WithComponents(service.NewWebServer("web", "localhost:8082", webServer), worker),
+		service.WithShutdownCallbacks(StoreCacheToDBCallback))
 	app.StartAndServe()
 }
 
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/dongma/imola/web"
+	"net/http"
+)
+
+// Component 被App统一管理的组件，例如HTTP服务、RPC服务、后台任务
+// 收到退出信号之后，App按照注册的顺序依次调用Stop
+type Component interface {
+	Name() string
+	// Start 阻塞直到组件退出，正常关闭时返回nil或者http.ErrServerClosed
+	Start() error
+	Stop(ctx context.Context) error
+}
+
+// Drainer 可以停止接收新请求的组件，App在等待已有请求处理完毕之前调用Drain
+type Drainer interface {
+	Drain()
+}
+
+// 确保Server也是一个Component
+var _ Component = &Server{}
+
+type funcComponent struct {
+	name  string
+	start func() error
+	stop  func(ctx context.Context) error
+}
+
+// NewComponent 使用start和stop构造组件，例如接入RPC服务
+//
+//	service.NewComponent("rpc", func() error {
+//		return rpcServer.Start(":8082")
+//	}, func(ctx context.Context) error {
+//		return rpcServer.Close()
+//	})
+func NewComponent(name string, start func() error, stop func(ctx context.Context) error) Component {
+	return &funcComponent{name: name, start: start, stop: stop}
+}
+
+func (f *funcComponent) Name() string {
+	return f.name
+}
+
+func (f *funcComponent) Start() error {
+	return f.start()
+}
+
+func (f *funcComponent) Stop(ctx context.Context) error {
+	return f.stop(ctx)
+}
+
+// WebServer 把web.HTTPServer接入App，draining状态下新的请求会得到503
+type WebServer struct {
+	name   string
+	addr   string
+	server *web.HTTPServer
+}
+
+func NewWebServer(name string, addr string, server *web.HTTPServer) *WebServer {
+	return &WebServer{name: name, addr: addr, server: server}
+}
+
+func (w *WebServer) Name() string {
+	return w.name
+}
+
+func (w *WebServer) Start() error {
+	return w.server.Start(w.addr)
+}
+
+func (w *WebServer) Drain() {
+	w.server.Drain()
+}
+
+func (w *WebServer) Stop(ctx context.Context) error {
+	return w.server.Shutdown(ctx)
+}
+
+// Worker 后台任务，Start的时候执行fn，Stop的时候取消传给fn的ctx并等待fn返回
+type Worker struct {
+	name   string
+	fn     func(ctx context.Context)
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewWorker fn需要在ctx被取消之后尽快返回
+func NewWorker(name string, fn func(ctx context.Context)) *Worker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Worker{
+		name:   name,
+		fn:     fn,
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+}
+
+func (w *Worker) Name() string {
+	return w.name
+}
+
+func (w *Worker) Start() error {
+	defer close(w.done)
+	w.fn(w.ctx)
+	return nil
+}
+
+func (w *Worker) Stop(ctx context.Context) error {
+	w.cancel()
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isClosedErr 组件正常关闭时返回的error
+func isClosedErr(err error) bool {
+	return err == nil || errors.Is(err, http.ErrServerClosed)
+}
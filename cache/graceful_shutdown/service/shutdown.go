@@ -17,6 +17,14 @@ type Option func(*App)
 // - 还希望用户知道，回调必须在一定时间内处理完毕，而且他必须显示处理超时错误
 type ShutdownCallback func(ctx context.Context)
 
+// WithComponents 注册需要App管理的组件，关闭时排在servers之后，按照注册的顺序依次关闭
+// 例如先关闭HTTP服务，再关闭RPC服务，最后停止后台任务
+func WithComponents(components ...Component) Option {
+	return func(app *App) {
+		app.components = append(app.components, components...)
+	}
+}
+
 func WithShutdownCallbacks(callbacks ...ShutdownCallback) Option {
 	return func(app *App) {
 		app.cbs = callbacks
@@ -25,6 +33,8 @@ func WithShutdownCallbacks(callbacks ...ShutdownCallback) Option {
 
 type App struct {
 	servers []*Server
+	// 所有需要管理的组件，包括servers
+	components []Component
 
 	// 优雅退出整个超时时间，默认30秒
 	shutdownTimeout time.Duration
@@ -45,6 +55,9 @@ func NewApp(servers []*Server, opts ...Option) *App {
 		servers:         servers,
 	}
 
+	for _, server := range servers {
+		res.components = append(res.components, server)
+	}
 	for _, opt := range opts {
 		opt(res)
 	}
@@ -52,15 +65,13 @@ func NewApp(servers []*Server, opts ...Option) *App {
 }
 
 func (app *App) StartAndServe() {
-	for _, server := range app.servers {
-		srv := server
+	for _, component := range app.components {
+		c := component
 		go func() {
-			if err := srv.Start(); err != nil {
-				if err == http.ErrServerClosed {
-					log.Printf("服务器%s已关闭", srv.name)
-				} else {
-					log.Printf("服务器%s异常退出", srv.name)
-				}
+			if err := c.Start(); isClosedErr(err) {
+				log.Printf("服务器%s已关闭", c.Name())
+			} else {
+				log.Printf("服务器%s异常退出 %v", c.Name(), err)
 			}
 		}()
 	}
@@ -87,28 +98,27 @@ func (app *App) StartAndServe() {
 // shutdown 设计里面的执行步骤，具体每一步可以用time.Sleep来模拟
 func (app *App) shutdown() {
 	log.Println("开始关闭应用，停止接收新请求")
-	for _, s := range app.servers {
+	for _, c := range app.components {
 		// note: 为什么这里不用并发控制（不用锁），也不用原子操作
-		s.rejectReq()
+		if d, ok := c.(Drainer); ok {
+			d.Drain()
+		}
 	}
 	log.Println("等待正在执行的请求完结")
 	// 这里可以改造为实时统计正在处理的请求数量，为0 则下一步
 	time.Sleep(app.waitTime)
 
 	log.Println("开始关闭服务器")
-	var wg sync.WaitGroup
-	wg.Add(len(app.servers))
-	for _, srv := range app.servers {
-		srvCp := srv
-		go func() {
-			if err := srvCp.stop(); err != nil {
-				log.Printf("关闭服务器失败%s \n", srvCp.name)
-			}
-			wg.Done()
-		}()
+	// 按照注册的顺序依次关闭，前面的组件可能还依赖后面的组件，例如HTTP服务依赖RPC服务
+	ctx, cancel := context.WithTimeout(context.Background(), app.shutdownTimeout)
+	for _, c := range app.components {
+		if err := c.Stop(ctx); err != nil {
+			log.Printf("关闭服务器失败%s %v\n", c.Name(), err)
+		}
 	}
+	cancel()
 
-	wg.Wait()
+	var wg sync.WaitGroup
 	log.Println("开始执行自定义回调")
 	wg.Add(len(app.cbs))
 	for _, cb := range app.cbs {
@@ -169,10 +179,24 @@ func (s *Server) Handle(pattern string, handler http.Handler) {
 	s.mux.Handle(pattern, handler)
 }
 
+func (s *Server) Name() string {
+	return s.name
+}
+
 func (s *Server) Start() error {
 	return s.srv.ListenAndServe()
 }
 
+// Drain 停止接收新请求
+func (s *Server) Drain() {
+	s.rejectReq()
+}
+
+// Stop 等待已有的请求处理完毕之后关闭服务器
+func (s *Server) Stop(ctx context.Context) error {
+	return s.stop1(ctx)
+}
+
 func (s *Server) rejectReq() {
 	s.mux.reject = true
 }
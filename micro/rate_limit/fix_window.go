@@ -29,24 +29,38 @@ func NewFixWindowLimiter(interval time.Duration, rate int64) *FixWindowLimiter {
 // BuildServerInterceptor 构建服务端限流
 func (t *FixWindowLimiter) BuildServerInterceptor() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
-		// 考虑t.cnt重置的问题
-		current := time.Now().UnixNano()
-		timestamp := atomic.LoadInt64(&t.timestamp)
-		cnt := atomic.LoadInt64(&t.cnt)
-		if timestamp+t.interval < current {
-			// 这意味着这是一个新窗口，重置窗口
-			if atomic.CompareAndSwapInt64(&t.timestamp, timestamp, current) {
-				atomic.CompareAndSwapInt64(&t.cnt, cnt, 0)
-			}
-		}
-
-		cnt = atomic.AddInt64(&t.cnt, 1)
-		if cnt > t.rate {
+		res, _ := t.Allow(ctx)
+		if !res.Allowed {
 			err = errors.New("触发瓶颈了")
-			atomic.AddInt64(&t.cnt, -1)
 			return
 		}
 		resp, err = handler(ctx, req)
 		return
 	}
 }
+
+// Allow 当前窗口内的请求数量没有超过rate时通过
+func (t *FixWindowLimiter) Allow(ctx context.Context) (Result, error) {
+	// 考虑t.cnt重置的问题
+	current := time.Now().UnixNano()
+	timestamp := atomic.LoadInt64(&t.timestamp)
+	cnt := atomic.LoadInt64(&t.cnt)
+	if timestamp+t.interval < current {
+		// 这意味着这是一个新窗口，重置窗口
+		if atomic.CompareAndSwapInt64(&t.timestamp, timestamp, current) {
+			atomic.CompareAndSwapInt64(&t.cnt, cnt, 0)
+		}
+	}
+	reset := time.Unix(0, atomic.LoadInt64(&t.timestamp)+t.interval)
+	res := Result{Limit: t.rate, Reset: reset}
+
+	cnt = atomic.AddInt64(&t.cnt, 1)
+	if cnt > t.rate {
+		atomic.AddInt64(&t.cnt, -1)
+		res.RetryAfter = reset.Sub(time.Unix(0, current))
+		return res, nil
+	}
+	res.Allowed = true
+	res.Remaining = t.rate - cnt
+	return res, nil
+}
@@ -8,11 +8,13 @@ import (
 
 type LeakyBucketLimiter struct {
 	producer *time.Ticker
+	interval time.Duration
 }
 
 func NewLeakyBucketLimiter(interval time.Duration) *LeakyBucketLimiter {
 	return &LeakyBucketLimiter{
 		producer: time.NewTicker(interval),
+		interval: interval,
 	}
 }
 
@@ -30,6 +32,21 @@ func (t *LeakyBucketLimiter) BuildServerInterceptor() grpc.UnaryServerIntercepto
 	}
 }
 
+// Allow 漏桶每隔interval放行一个请求，当前没有可以放行的名额时直接拒绝
+func (t *LeakyBucketLimiter) Allow(ctx context.Context) (Result, error) {
+	res := Result{Limit: 1}
+	select {
+	case <-ctx.Done():
+		return res, ctx.Err()
+	case <-t.producer.C:
+		res.Allowed = true
+	default:
+		res.RetryAfter = t.interval
+	}
+	res.Reset = time.Now().Add(t.interval)
+	return res, nil
+}
+
 func (t *LeakyBucketLimiter) Close() error {
 	t.producer.Stop()
 	return nil
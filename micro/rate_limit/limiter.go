@@ -0,0 +1,32 @@
+package rate_limit
+
+import (
+	"context"
+	"time"
+)
+
+// Limiter 限流算法的统一抽象，gRPC interceptor和web middleware都基于它实现
+type Limiter interface {
+	// Allow 判断当前请求能否通过，不会阻塞等待
+	Allow(ctx context.Context) (Result, error)
+}
+
+// Result 一次限流判断的结果，web middleware会根据它设置X-RateLimit-*响应头
+type Result struct {
+	Allowed bool
+	// Limit 一个周期内允许通过的请求数量
+	Limit int64
+	// Remaining 当前周期内剩余可以通过的请求数量
+	Remaining int64
+	// RetryAfter 被拒绝时，建议等待多久之后再重试
+	RetryAfter time.Duration
+	// Reset 当前周期结束的时间
+	Reset time.Time
+}
+
+var (
+	_ Limiter = &FixWindowLimiter{}
+	_ Limiter = &SlideWindowLimiter{}
+	_ Limiter = &TokenBucketLimiter{}
+	_ Limiter = &LeakyBucketLimiter{}
+)
@@ -27,36 +27,43 @@ func NewSlideWindowLimiter(interval time.Duration, rate int) *SlideWindowLimiter
 func (t *SlideWindowLimiter) BuildServerInterceptor() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler) (resp interface{}, err error) {
-		now := time.Now().UnixNano()
-		boundary := now - t.interval
-
-		// 快路径，窗口未满的情况，直接写入
-		t.mutex.Lock()
-		length := t.queue.Len()
-		if length < t.rate {
-			resp, err = handler(ctx, req)
-			// 记住了请求的时间戳
-			t.queue.PushBack(now)
-			t.mutex.Unlock()
-			return
-		}
-
-		// 窗口满的情况，则需要进行淘汰（不在窗口内的）
-		timestamp := t.queue.Front()
-		// 这个循环把所有不在窗口内的数据都删掉了
-		for timestamp != nil && timestamp.Value.(int64) < boundary {
-			t.queue.Remove(timestamp)
-			timestamp = t.queue.Front()
-		}
-		length = t.queue.Len()
-		t.mutex.Unlock()
-		if length >= t.rate {
+		res, _ := t.Allow(ctx)
+		if !res.Allowed {
 			err = errors.New("到达瓶颈")
 			return
 		}
 		resp, err = handler(ctx, req)
-		// 记住了请求的时间戳
-		t.queue.PushBack(now)
 		return
 	}
 }
+
+// Allow 最近interval内通过的请求数量没有达到rate时通过
+func (t *SlideWindowLimiter) Allow(ctx context.Context) (Result, error) {
+	now := time.Now().UnixNano()
+	boundary := now - t.interval
+	rate := int64(t.rate)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	// 每次都淘汰不在窗口内的数据，否则Remaining和Reset会把已经过期的请求算进去
+	timestamp := t.queue.Front()
+	for timestamp != nil && timestamp.Value.(int64) < boundary {
+		t.queue.Remove(timestamp)
+		timestamp = t.queue.Front()
+	}
+	res := Result{Limit: rate, Reset: time.Unix(0, now+t.interval)}
+	if front := t.queue.Front(); front != nil {
+		// 最早的请求滑出窗口之后，才有新的名额
+		res.Reset = time.Unix(0, front.Value.(int64)+t.interval)
+	}
+	length := int64(t.queue.Len())
+	if length >= rate {
+		res.RetryAfter = res.Reset.Sub(time.Unix(0, now))
+		return res, nil
+	}
+	// 记住了请求的时间戳
+	t.queue.PushBack(now)
+	res.Allowed = true
+	res.Remaining = rate - length - 1
+	return res, nil
+}
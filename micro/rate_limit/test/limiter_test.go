@@ -0,0 +1,80 @@
+package test
+
+import (
+	"context"
+	"github.com/dongma/imola/micro/rate_limit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	testCases := []struct {
+		name    string
+		limiter rate_limit.Limiter
+		limit   int64
+	}{
+		{
+			name:    "fix window",
+			limiter: rate_limit.NewFixWindowLimiter(time.Minute, 2),
+			limit:   2,
+		},
+		{
+			name:    "slide window",
+			limiter: rate_limit.NewSlideWindowLimiter(time.Minute, 2),
+			limit:   2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for i := int64(1); i <= tc.limit; i++ {
+				res, err := tc.limiter.Allow(context.Background())
+				require.NoError(t, err)
+				assert.True(t, res.Allowed)
+				assert.Equal(t, tc.limit, res.Limit)
+				assert.Equal(t, tc.limit-i, res.Remaining)
+			}
+			res, err := tc.limiter.Allow(context.Background())
+			require.NoError(t, err)
+			assert.False(t, res.Allowed)
+			assert.Equal(t, int64(0), res.Remaining)
+			assert.True(t, res.RetryAfter > 0 && res.RetryAfter <= time.Minute)
+		})
+	}
+}
+
+func TestTokenBucketLimiter_Allow(t *testing.T) {
+	ch := make(chan struct{}, 2)
+	ch <- struct{}{}
+	limiter := &rate_limit.TokenBucketLimiter{
+		Tokens:  ch,
+		CloseCh: make(chan struct{}),
+	}
+	res, err := limiter.Allow(context.Background())
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, int64(2), res.Limit)
+
+	// 没有令牌时直接拒绝，不会等待
+	res, err = limiter.Allow(context.Background())
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+}
+
+func TestSlideWindowLimiter_Expire(t *testing.T) {
+	limiter := rate_limit.NewSlideWindowLimiter(50*time.Millisecond, 3)
+	res, err := limiter.Allow(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), res.Remaining)
+
+	// 窗口没有满的时候，过期的请求同样不能算进Remaining和Reset
+	time.Sleep(60 * time.Millisecond)
+	now := time.Now()
+	res, err = limiter.Allow(context.Background())
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, int64(2), res.Remaining)
+	assert.True(t, res.Reset.After(now.Add(40*time.Millisecond)))
+}
@@ -10,6 +10,8 @@ import (
 type TokenBucketLimiter struct {
 	Tokens  chan struct{}
 	CloseCh chan struct{}
+	// 产生令牌的间隔
+	interval time.Duration
 }
 
 // NewTokenBucketLimiter interval表示间隔多久产生一个令牌
@@ -33,8 +35,9 @@ func NewTokenBucketLimiter(capacity int, interval time.Duration) *TokenBucketLim
 		}
 	}()
 	return &TokenBucketLimiter{
-		Tokens:  ch,
-		CloseCh: closeCh,
+		Tokens:   ch,
+		CloseCh:  closeCh,
+		interval: interval,
 	}
 }
 
@@ -59,6 +62,28 @@ func (t *TokenBucketLimiter) BuildServerInterceptor() grpc.UnaryServerIntercepto
 	}
 }
 
+// Allow 能够立刻拿到令牌时通过，和interceptor不同，拿不到令牌不会等待
+func (t *TokenBucketLimiter) Allow(ctx context.Context) (Result, error) {
+	res := Result{Limit: int64(cap(t.Tokens))}
+	select {
+	case <-t.CloseCh:
+		return res, errors.New("缺乏保护，拒绝请求")
+	case <-ctx.Done():
+		return res, ctx.Err()
+	default:
+	}
+	select {
+	case <-t.Tokens:
+		res.Allowed = true
+	default:
+		res.RetryAfter = t.interval
+	}
+	res.Remaining = int64(len(t.Tokens))
+	// 令牌桶装满所需要的时间
+	res.Reset = time.Now().Add(time.Duration(res.Limit-res.Remaining) * t.interval)
+	return res, nil
+}
+
 func (t *TokenBucketLimiter) Close() error {
 	close(t.CloseCh)
 	return nil
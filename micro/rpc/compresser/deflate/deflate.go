@@ -0,0 +1,38 @@
+package deflate
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+)
+
+// DeflateCompresser HTTP中的deflate编码实际上是zlib格式（RFC 1950），这里保持一致
+type DeflateCompresser struct {
+}
+
+func (d DeflateCompresser) Code() byte {
+	return 2
+}
+
+func (d DeflateCompresser) Compress(data []byte) ([]byte, error) {
+	res := bytes.NewBuffer(nil)
+	w := zlib.NewWriter(res)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return res.Bytes(), nil
+}
+
+func (d DeflateCompresser) UnCompress(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	return io.ReadAll(r)
+}
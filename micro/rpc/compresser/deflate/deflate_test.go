@@ -0,0 +1,34 @@
+package deflate
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDeflateCompresser(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input []byte
+	}{
+		{
+			name:  "hello world",
+			input: []byte("hello world"),
+		},
+		{
+			name:  "empty",
+			input: []byte{},
+		},
+	}
+
+	c := DeflateCompresser{}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := c.Compress(tc.input)
+			require.NoError(t, err)
+			data, err = c.UnCompress(data)
+			require.NoError(t, err)
+			assert.Equal(t, tc.input, data)
+		})
+	}
+}
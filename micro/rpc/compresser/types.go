@@ -20,3 +20,44 @@ func (d DoNothing) Compress(data []byte) ([]byte, error) {
 func (d DoNothing) UnCompress(data []byte) ([]byte, error) {
 	return data, nil
 }
+
+// Registry 按照编码和名字管理Compresser，名字和HTTP的Content-Encoding保持一致，例如gzip
+// RPC按照编码查找，HTTP按照名字查找，这样两边可以共用同一份压缩算法
+type Registry struct {
+	names       []string
+	byName      map[string]Compresser
+	compressers map[byte]Compresser
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		byName:      make(map[string]Compresser, 4),
+		compressers: make(map[byte]Compresser, 4),
+	}
+}
+
+// Register 注册一个Compresser，先注册的在协商时优先级更高
+func (r *Registry) Register(name string, c Compresser) {
+	if _, ok := r.byName[name]; !ok {
+		r.names = append(r.names, name)
+	}
+	r.byName[name] = c
+	r.compressers[c.Code()] = c
+}
+
+// Get 按照RPC协议中的编码查找
+func (r *Registry) Get(code byte) (Compresser, bool) {
+	c, ok := r.compressers[code]
+	return c, ok
+}
+
+// GetByName 按照名字查找
+func (r *Registry) GetByName(name string) (Compresser, bool) {
+	c, ok := r.byName[name]
+	return c, ok
+}
+
+// Names 按照注册顺序返回所有的名字
+func (r *Registry) Names() []string {
+	return r.names
+}
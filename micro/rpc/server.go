@@ -38,6 +38,14 @@ func (s *Server) RegisterCompresser(c compresser.Compresser) {
 	s.compressers[c.Code()] = c
 }
 
+// RegisterCompressers 注册registry中所有的Compresser，和HTTP服务共用同一个registry
+func (s *Server) RegisterCompressers(registry *compresser.Registry) {
+	for _, name := range registry.Names() {
+		c, _ := registry.GetByName(name)
+		s.RegisterCompresser(c)
+	}
+}
+
 func (s *Server) RegisterService(service Service) {
 	s.services[service.Name()] = ReflectionStub{
 		s:           service,
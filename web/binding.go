@@ -0,0 +1,336 @@
+package web
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 绑定数据时使用的struct tag，例如
+//
+//	type UserReq struct {
+//		ID       int64     `path:"id"`
+//		Page     int       `query:"page"`
+//		Tags     []string  `query:"tag"`
+//		Name     string    `form:"name"`
+//		Token    string    `header:"X-Token"`
+//		Birthday time.Time `query:"birthday" time_format:"2006-01-02"`
+//	}
+const (
+	bindTagQuery      = "query"
+	bindTagForm       = "form"
+	bindTagPath       = "path"
+	bindTagHeader     = "header"
+	bindTagTimeFormat = "time_format"
+)
+
+var (
+	errBindTarget = errors.New("web: 绑定的目标必须是非nil的结构体指针")
+
+	timeType            = reflect.TypeOf(time.Time{})
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// BindError 绑定某个字段失败，Field是结构体字段名，Source是数据来源，例如query、form
+type BindError struct {
+	Field  string
+	Source string
+	Key    string
+	Value  string
+	Err    error
+}
+
+func (e *BindError) Error() string {
+	return fmt.Sprintf("web: 绑定字段 %s 失败, %s[%s]=%q: %v", e.Field, e.Source, e.Key, e.Value, e.Err)
+}
+
+func (e *BindError) Unwrap() error {
+	return e.Err
+}
+
+// Bind 依次从路径参数、查询参数、请求头、表单中绑定数据，请求体是JSON时同样会解析请求体
+// 后面的数据来源会覆盖前面的，全部绑定完成之后按照validate tag进行校验
+func (c *Context) Bind(val any) error {
+	return c.validate(val, "", c.bind(val))
+}
+
+// BindQuery 使用query tag从查询参数中绑定数据，只校验带有query tag的字段
+func (c *Context) BindQuery(val any) error {
+	return c.validate(val, bindTagQuery, c.bindQuery(val))
+}
+
+// BindForm 使用form tag从表单中绑定数据，和FromValue一样，表单数据包含了查询参数，只校验带有form tag的字段
+func (c *Context) BindForm(val any) error {
+	return c.validate(val, bindTagForm, c.bindForm(val))
+}
+
+// BindPath 使用path tag从路径参数中绑定数据，只校验带有path tag的字段
+func (c *Context) BindPath(val any) error {
+	return c.validate(val, bindTagPath, c.bindPath(val))
+}
+
+// BindHeader 使用header tag从请求头中绑定数据，只校验带有header tag的字段
+func (c *Context) BindHeader(val any) error {
+	return c.validate(val, bindTagHeader, c.bindHeader(val))
+}
+
+// Validate 校验整个结构体，分多次绑定同一个结构体之后可以用它做一次完整的校验
+func (c *Context) Validate(val any) error {
+	return c.validate(val, "", nil)
+}
+
+// validate 绑定成功之后进行校验，source不为空时只校验这个数据来源的字段
+// 出现的错误记录在ctx.Err中，方便errhdl这种middleware统一处理
+func (c *Context) validate(val any, source string, err error) error {
+	if err == nil {
+		err = validateSource(val, source)
+	}
+	if err != nil {
+		c.Err = err
+	}
+	return err
+}
+
+func (c *Context) bind(val any) error {
+	if err := c.bindPath(val); err != nil {
+		return err
+	}
+	if err := c.bindQuery(val); err != nil {
+		return err
+	}
+	if err := c.bindHeader(val); err != nil {
+		return err
+	}
+	contentType, _, _ := mime.ParseMediaType(c.Req.Header.Get("Content-Type"))
+	switch contentType {
+	case "application/json":
+		if c.Req.Body == nil || c.Req.Body == http.NoBody {
+			return nil
+		}
+		return c.bindJSON(val)
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		return c.bindForm(val)
+	}
+	return nil
+}
+
+func (c *Context) bindQuery(val any) error {
+	if c.queryValues == nil {
+		c.queryValues = c.Req.URL.Query()
+	}
+	return bindValues(val, bindTagQuery, func(key string) []string {
+		return c.queryValues[key]
+	})
+}
+
+func (c *Context) bindForm(val any) error {
+	contentType, _, _ := mime.ParseMediaType(c.Req.Header.Get("Content-Type"))
+	var err error
+	if contentType == "multipart/form-data" {
+		err = c.Req.ParseMultipartForm(32 << 20)
+	} else {
+		err = c.Req.ParseForm()
+	}
+	if err != nil {
+		return err
+	}
+	return bindValues(val, bindTagForm, func(key string) []string {
+		return c.Req.Form[key]
+	})
+}
+
+func (c *Context) bindPath(val any) error {
+	return bindValues(val, bindTagPath, func(key string) []string {
+		v, ok := c.PathParams[key]
+		if !ok {
+			return nil
+		}
+		return []string{v}
+	})
+}
+
+func (c *Context) bindHeader(val any) error {
+	return bindValues(val, bindTagHeader, func(key string) []string {
+		return c.Req.Header.Values(key)
+	})
+}
+
+// bindValues 遍历结构体字段，使用lookup找到tag对应的值，没有值的字段保持不变
+func bindValues(val any, tag string, lookup func(key string) []string) error {
+	v := reflect.ValueOf(val)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return errBindTarget
+	}
+	return bindStruct(v.Elem(), tag, lookup)
+}
+
+func bindStruct(v reflect.Value, tag string, lookup func(key string) []string) error {
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		fv := v.Field(i)
+		key, _, _ := strings.Cut(field.Tag.Get(tag), ",")
+		// 没有tag的嵌入结构体，展开绑定
+		if key == "" && field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if err := bindStruct(fv, tag, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+		if key == "" && field.Anonymous && field.Type.Kind() == reflect.Pointer &&
+			field.Type.Elem().Kind() == reflect.Struct {
+			if err := bindEmbeddedPointer(fv, tag, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+		if !field.IsExported() || key == "" || key == "-" {
+			continue
+		}
+		vals := lookup(key)
+		if len(vals) == 0 {
+			continue
+		}
+		if err := setField(fv, vals, field); err != nil {
+			var bindErr *BindError
+			if errors.As(err, &bindErr) {
+				bindErr.Field, bindErr.Source, bindErr.Key = field.Name, tag, key
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// bindEmbeddedPointer 嵌入的*Base，为nil时只有请求中带了Base的字段才分配，避免凭空多出一个空的Base
+func bindEmbeddedPointer(fv reflect.Value, tag string, lookup func(key string) []string) error {
+	if !fv.IsNil() {
+		return bindStruct(fv.Elem(), tag, lookup)
+	}
+	if !fv.CanSet() {
+		// 未导出的嵌入指针没有办法分配
+		return nil
+	}
+	found := false
+	ptr := reflect.New(fv.Type().Elem())
+	err := bindStruct(ptr.Elem(), tag, func(key string) []string {
+		vals := lookup(key)
+		if len(vals) > 0 {
+			found = true
+		}
+		return vals
+	})
+	if err != nil {
+		return err
+	}
+	if found {
+		fv.Set(ptr)
+	}
+	return nil
+}
+
+// setField 切片使用所有的值，其它类型只使用第一个值
+func setField(fv reflect.Value, vals []string, field reflect.StructField) error {
+	if fv.Kind() == reflect.Slice && !isTextValue(fv.Type()) {
+		slice := reflect.MakeSlice(fv.Type(), len(vals), len(vals))
+		for i, s := range vals {
+			if err := setValue(slice.Index(i), s, field); err != nil {
+				return err
+			}
+		}
+		fv.Set(slice)
+		return nil
+	}
+	return setValue(fv, vals[0], field)
+}
+
+// setValue 把字符串转换为fv的类型，空字符串对于非字符串类型来说保持零值，指针保持nil
+func setValue(fv reflect.Value, s string, field reflect.StructField) error {
+	if fv.Kind() == reflect.Pointer {
+		if s == "" {
+			return nil
+		}
+		if fv.IsNil() {
+			fv.Set(reflect.New(fv.Type().Elem()))
+		}
+		return setValue(fv.Elem(), s, field)
+	}
+	if s == "" && fv.Kind() != reflect.String {
+		return nil
+	}
+	err := convert(fv, s, field)
+	if err != nil {
+		return &BindError{Value: s, Err: err}
+	}
+	return nil
+}
+
+func convert(fv reflect.Value, s string, field reflect.StructField) error {
+	switch fv.Type() {
+	case timeType:
+		layout := field.Tag.Get(bindTagTimeFormat)
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		t, err := time.Parse(layout, s)
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	case durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+	if fv.CanAddr() && reflect.PointerTo(fv.Type()).Implements(textUnmarshalerType) {
+		return fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	default:
+		return fmt.Errorf("不支持的类型 %s", fv.Type())
+	}
+	return nil
+}
+
+// isTextValue 实现了TextUnmarshaler的切片类型，例如net.IP，作为一个整体来转换
+func isTextValue(typ reflect.Type) bool {
+	return reflect.PointerTo(typ).Implements(textUnmarshalerType)
+}
@@ -0,0 +1,198 @@
+package web
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bindPage struct {
+	Page int `query:"page"`
+	Size int `query:"size"`
+}
+
+type bindUserReq struct {
+	bindPage
+	ID       int64         `path:"id"`
+	Name     string        `query:"name" form:"name"`
+	Tags     []string      `query:"tag"`
+	Scores   []float64     `query:"score"`
+	Admin    bool          `query:"admin"`
+	Age      *uint8        `query:"age"`
+	Birthday time.Time     `query:"birthday" time_format:"2006-01-02"`
+	Timeout  time.Duration `query:"timeout"`
+	IP       net.IP        `query:"ip"`
+	Token    string        `header:"X-Token"`
+	Ignore   string        `query:"-"`
+	email    string        `query:"email"`
+}
+
+func TestContext_Bind(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost,
+		"/user/12?page=2&size=10&name=tom&tag=a&tag=b&score=1.5&score=2&admin=true&age=18"+
+			"&birthday=2000-01-02&timeout=3s&ip=127.0.0.1&Ignore=x&email=a@b.com",
+		strings.NewReader("name=jerry"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Token", "my-token")
+	ctx := &Context{
+		Req:        req,
+		PathParams: map[string]string{"id": "12"},
+	}
+
+	var user bindUserReq
+	require.NoError(t, ctx.Bind(&user))
+	age := uint8(18)
+	assert.Equal(t, bindUserReq{
+		bindPage: bindPage{Page: 2, Size: 10},
+		ID:       12,
+		// 表单中的值覆盖了查询参数
+		Name:     "jerry",
+		Tags:     []string{"a", "b"},
+		Scores:   []float64{1.5, 2},
+		Admin:    true,
+		Age:      &age,
+		Birthday: time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC),
+		Timeout:  3 * time.Second,
+		IP:       net.ParseIP("127.0.0.1"),
+		Token:    "my-token",
+	}, user)
+}
+
+type BindSort struct {
+	Sort string `query:"sort"`
+}
+
+type bindListReq struct {
+	*BindSort
+	Limit *int    `query:"limit"`
+	Name  *string `query:"name"`
+}
+
+func TestContext_BindEmbeddedPointer(t *testing.T) {
+	testCases := []struct {
+		name string
+		url  string
+		want bindListReq
+	}{
+		{
+			name: "allocate",
+			url:  "/list?sort=id&limit=5&name=tom",
+			want: bindListReq{BindSort: &BindSort{Sort: "id"}, Limit: intPtr(5), Name: strPtr("tom")},
+		},
+		{
+			// 请求中没有嵌入结构体的字段时不分配
+			name: "missing",
+			url:  "/list",
+		},
+		{
+			// 空字符串不会分配指针
+			name: "empty",
+			url:  "/list?sort=&limit=&name=",
+			want: bindListReq{BindSort: &BindSort{}},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := &Context{Req: httptest.NewRequest(http.MethodGet, tc.url, nil)}
+			var req bindListReq
+			require.NoError(t, ctx.BindQuery(&req))
+			assert.Equal(t, tc.want, req)
+		})
+	}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestContext_BindJSON(t *testing.T) {
+	type loginReq struct {
+		ID       int64  `path:"id"`
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}
+	req := httptest.NewRequest(http.MethodPost, "/user/12",
+		strings.NewReader(`{"name":"tom","password":"123"}`))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	ctx := &Context{
+		Req:        req,
+		PathParams: map[string]string{"id": "12"},
+	}
+	var login loginReq
+	require.NoError(t, ctx.Bind(&login))
+	assert.Equal(t, loginReq{ID: 12, Name: "tom", Password: "123"}, login)
+}
+
+func TestContext_BindError(t *testing.T) {
+	testCases := []struct {
+		name      string
+		url       string
+		val       any
+		wantField string
+		wantErr   error
+	}{
+		{
+			name:    "not pointer",
+			url:     "/user",
+			val:     bindPage{},
+			wantErr: errBindTarget,
+		},
+		{
+			name:    "nil pointer",
+			url:     "/user",
+			val:     (*bindPage)(nil),
+			wantErr: errBindTarget,
+		},
+		{
+			name:      "int",
+			url:       "/user?size=abc",
+			val:       &bindUserReq{},
+			wantField: "Size",
+		},
+		{
+			name:      "overflow",
+			url:       "/user?age=256",
+			val:       &bindUserReq{},
+			wantField: "Age",
+		},
+		{
+			name:      "slice",
+			url:       "/user?score=1&score=x",
+			val:       &bindUserReq{},
+			wantField: "Scores",
+		},
+		{
+			name:      "time",
+			url:       "/user?birthday=2000/01/02",
+			val:       &bindUserReq{},
+			wantField: "Birthday",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := &Context{
+				Req: httptest.NewRequest(http.MethodGet, tc.url, nil),
+			}
+			err := ctx.BindQuery(tc.val)
+			if tc.wantErr != nil {
+				assert.Equal(t, tc.wantErr, err)
+				return
+			}
+			var bindErr *BindError
+			require.True(t, errors.As(err, &bindErr))
+			assert.Equal(t, tc.wantField, bindErr.Field)
+			assert.Equal(t, bindTagQuery, bindErr.Source)
+		})
+	}
+}
@@ -0,0 +1,449 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUploadNotFound         = errors.New("web: 上传任务不存在")
+	ErrUploadInvalidChunk     = errors.New("web: 分片不合法")
+	ErrUploadIncomplete       = errors.New("web: 还有分片没有上传")
+	ErrUploadChecksumMismatch = errors.New("web: 文件校验和不一致")
+
+	uploadIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+)
+
+const (
+	defaultChunkSize    int64 = 5 << 20
+	defaultMaxChunkSize int64 = 32 << 20
+	chunkMetaFile             = "meta.json"
+	chunkPartSuffix           = ".part"
+)
+
+// ChunkUploader 分片上传，适合大文件和网络不稳定的移动端，分片和任务信息都保存在磁盘上，服务重启之后依旧可以续传
+// 协议分为三步，路由需要用户自己注册，例如
+//
+//	uploader := &web.ChunkUploader{Dir: "./uploads", MaxFileSize: 1 << 30}
+//	server.POST("/uploads", uploader.Init())
+//	server.GET("/uploads/:id", uploader.Status())
+//	server.PUT("/uploads/:id/chunks/:index", uploader.Chunk())
+//	server.POST("/uploads/:id/complete", uploader.Complete())
+//	server.DELETE("/uploads/:id", uploader.Abort())
+//
+// Init 的请求体是ChunkInitReq，返回ChunkUploadStatus，里面有upload_id和分片数量。
+// Chunk 的请求体是第index个分片的原始数据，分片从0开始，除了最后一个都必须是chunk_size大小，
+// 可以通过X-Chunk-Sha256请求头校验分片，重复上传同一个分片会覆盖之前的；
+// 断线之后通过Status拿到已经收到的分片，只上传缺少的部分。
+// Complete 合并分片，校验大小、SHA-256和文件类型，返回UploadedFile。
+type ChunkUploader struct {
+	// Dir 合并之后的文件保存的目录
+	Dir string
+	// TempDir 保存分片的目录，默认是Dir/.chunks
+	TempDir string
+	// MaxFileSize 文件的大小上限，0表示不限制
+	MaxFileSize int64
+	// MaxChunkSize 客户端可以指定的最大分片，默认32MB
+	MaxChunkSize int64
+	// AllowedExts 和 AllowedMIMETypes 的含义和FileUploader一样
+	AllowedExts      []string
+	AllowedMIMETypes []string
+	// UploadIDParam 和 ChunkIndexParam 读取upload id和分片序号的路径参数名，默认是id和index
+	UploadIDParam   string
+	ChunkIndexParam string
+}
+
+// ChunkInitReq 初始化分片上传，ChunkSize为0时使用5MB，SHA256不为空时Complete会校验整个文件
+type ChunkInitReq struct {
+	Filename  string `json:"filename"`
+	Size      int64  `json:"size"`
+	ChunkSize int64  `json:"chunk_size"`
+	SHA256    string `json:"sha256"`
+}
+
+// ChunkUploadStatus 上传任务的状态，Received是已经收到的分片序号
+type ChunkUploadStatus struct {
+	UploadID  string `json:"upload_id"`
+	Filename  string `json:"filename"`
+	Size      int64  `json:"size"`
+	ChunkSize int64  `json:"chunk_size"`
+	Chunks    int    `json:"chunks"`
+	Received  []int  `json:"received"`
+}
+
+type chunkMeta struct {
+	ID        string    `json:"id"`
+	Filename  string    `json:"filename"`
+	Size      int64     `json:"size"`
+	ChunkSize int64     `json:"chunk_size"`
+	Chunks    int       `json:"chunks"`
+	SHA256    string    `json:"sha256,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// chunkLen 第index个分片应该有多大
+func (m *chunkMeta) chunkLen(index int) int64 {
+	if index == m.Chunks-1 {
+		return m.Size - int64(index)*m.ChunkSize
+	}
+	return m.ChunkSize
+}
+
+func (c *ChunkUploader) rule() uploadRule {
+	return uploadRule{maxSize: c.MaxFileSize, exts: c.AllowedExts, mimeTypes: c.AllowedMIMETypes}
+}
+
+func (c *ChunkUploader) tempDir() string {
+	if c.TempDir != "" {
+		return c.TempDir
+	}
+	return filepath.Join(c.Dir, ".chunks")
+}
+
+func (c *ChunkUploader) uploadDir(id string) string {
+	return filepath.Join(c.tempDir(), id)
+}
+
+// Init 创建上传任务
+func (c *ChunkUploader) Init() HandleFunc {
+	return func(ctx *Context) {
+		var req ChunkInitReq
+		if err := ctx.BindJSON(&req); err != nil {
+			_ = ctx.RespString(http.StatusBadRequest, "web: 请求格式不正确")
+			return
+		}
+		meta, err := c.init(req)
+		if err != nil {
+			respUploadErr(ctx, err)
+			return
+		}
+		_ = ctx.RespJSON(http.StatusCreated, c.status(meta, nil))
+	}
+}
+
+func (c *ChunkUploader) init(req ChunkInitReq) (*chunkMeta, error) {
+	if req.Filename == "" || req.Size <= 0 {
+		return nil, fmt.Errorf("%w: 文件名和大小不能为空", ErrUploadInvalidChunk)
+	}
+	if c.MaxFileSize > 0 && req.Size > c.MaxFileSize {
+		return nil, ErrUploadTooLarge
+	}
+	if !c.rule().extAllowed(req.Filename) {
+		return nil, ErrUploadTypeNotAllowed
+	}
+	maxChunkSize := c.MaxChunkSize
+	if maxChunkSize <= 0 {
+		maxChunkSize = defaultMaxChunkSize
+	}
+	chunkSize := req.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = defaultChunkSize
+	}
+	if chunkSize < 0 || chunkSize > maxChunkSize {
+		return nil, fmt.Errorf("%w: 分片大小必须在 (0, %d] 之间", ErrUploadInvalidChunk, maxChunkSize)
+	}
+	meta := &chunkMeta{
+		ID:        randomHex(16),
+		Filename:  req.Filename,
+		Size:      req.Size,
+		ChunkSize: chunkSize,
+		Chunks:    int((req.Size + chunkSize - 1) / chunkSize),
+		SHA256:    strings.ToLower(req.SHA256),
+		CreatedAt: time.Now(),
+	}
+	dir := c.uploadDir(meta.ID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(filepath.Join(dir, chunkMetaFile), data, 0o644); err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	return meta, nil
+}
+
+// Status 查询已经收到的分片，用于断线之后续传
+func (c *ChunkUploader) Status() HandleFunc {
+	return func(ctx *Context) {
+		meta, err := c.loadMeta(ctx)
+		if err != nil {
+			respUploadErr(ctx, err)
+			return
+		}
+		received, err := c.received(meta)
+		if err != nil {
+			respUploadErr(ctx, err)
+			return
+		}
+		_ = ctx.RespJSON(http.StatusOK, c.status(meta, received))
+	}
+}
+
+// Chunk 上传一个分片
+func (c *ChunkUploader) Chunk() HandleFunc {
+	return func(ctx *Context) {
+		meta, err := c.loadMeta(ctx)
+		if err != nil {
+			respUploadErr(ctx, err)
+			return
+		}
+		param := c.ChunkIndexParam
+		if param == "" {
+			param = "index"
+		}
+		index, err := ctx.PathValue(param).ToInt64()
+		if err != nil || index < 0 || index >= int64(meta.Chunks) {
+			respUploadErr(ctx, fmt.Errorf("%w: 分片序号不正确", ErrUploadInvalidChunk))
+			return
+		}
+		if err = c.saveChunk(meta, int(index), ctx.Req.Body, ctx.Req.Header.Get("X-Chunk-Sha256")); err != nil {
+			respUploadErr(ctx, err)
+			return
+		}
+		received, err := c.received(meta)
+		if err != nil {
+			respUploadErr(ctx, err)
+			return
+		}
+		_ = ctx.RespJSON(http.StatusOK, c.status(meta, received))
+	}
+}
+
+func (c *ChunkUploader) saveChunk(meta *chunkMeta, index int, body io.Reader, checksum string) error {
+	if body == nil {
+		return fmt.Errorf("%w: 分片不能为空", ErrUploadInvalidChunk)
+	}
+	dir := c.uploadDir(meta.ID)
+	tmp, err := os.CreateTemp(dir, ".chunk-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+	want := meta.chunkLen(index)
+	hasher := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, hasher), io.LimitReader(body, want+1))
+	if err != nil {
+		return err
+	}
+	if n != want {
+		return fmt.Errorf("%w: 第 %d 个分片应该是 %d 字节, 实际为 %d 字节", ErrUploadInvalidChunk, index, want, n)
+	}
+	if checksum != "" && !strings.EqualFold(checksum, hex.EncodeToString(hasher.Sum(nil))) {
+		return fmt.Errorf("%w: 第 %d 个分片", ErrUploadChecksumMismatch, index)
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	// rename是原子的，同一个分片并发或者重复上传都不会留下写了一半的文件
+	return os.Rename(tmp.Name(), filepath.Join(dir, strconv.Itoa(index)+chunkPartSuffix))
+}
+
+// Complete 合并所有分片，成功之后删除分片
+// 整个文件的校验和不一致时分片已经不可信，任务会被删除，客户端需要重新上传
+func (c *ChunkUploader) Complete() HandleFunc {
+	return func(ctx *Context) {
+		meta, err := c.loadMeta(ctx)
+		if err != nil {
+			respUploadErr(ctx, err)
+			return
+		}
+		file, err := c.complete(meta)
+		if err != nil {
+			respUploadErr(ctx, err)
+			return
+		}
+		_ = ctx.RespJSON(http.StatusOK, file)
+	}
+}
+
+func (c *ChunkUploader) complete(meta *chunkMeta) (UploadedFile, error) {
+	received, err := c.received(meta)
+	if err != nil {
+		return UploadedFile{}, err
+	}
+	if len(received) != meta.Chunks {
+		return UploadedFile{}, fmt.Errorf("%w: 收到 %d/%d 个分片", ErrUploadIncomplete, len(received), meta.Chunks)
+	}
+	dir := c.uploadDir(meta.ID)
+	paths := make([]string, 0, meta.Chunks)
+	for i := 0; i < meta.Chunks; i++ {
+		paths = append(paths, filepath.Join(dir, strconv.Itoa(i)+chunkPartSuffix))
+	}
+	src := &chunkReader{paths: paths}
+	defer src.Close()
+	file, err := c.rule().store(randomFilePath(c.Dir, meta.Filename), meta.Filename, src)
+	if err != nil {
+		return UploadedFile{}, err
+	}
+	if file.Size != meta.Size || (meta.SHA256 != "" && meta.SHA256 != file.SHA256) {
+		_ = os.Remove(file.Path)
+		_ = os.RemoveAll(dir)
+		return UploadedFile{}, ErrUploadChecksumMismatch
+	}
+	_ = os.RemoveAll(dir)
+	return file, nil
+}
+
+// Abort 放弃上传，删除所有分片
+func (c *ChunkUploader) Abort() HandleFunc {
+	return func(ctx *Context) {
+		meta, err := c.loadMeta(ctx)
+		if err != nil {
+			respUploadErr(ctx, err)
+			return
+		}
+		if err = os.RemoveAll(c.uploadDir(meta.ID)); err != nil {
+			respUploadErr(ctx, err)
+			return
+		}
+		ctx.RespStatusCode = http.StatusNoContent
+	}
+}
+
+// CleanExpired 删除创建时间超过maxAge还没有完成的任务，可以定时调用
+func (c *ChunkUploader) CleanExpired(maxAge time.Duration) error {
+	entries, err := os.ReadDir(c.tempDir())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	deadline := time.Now().Add(-maxAge)
+	for _, entry := range entries {
+		if !entry.IsDir() || !uploadIDPattern.MatchString(entry.Name()) {
+			continue
+		}
+		meta, err := c.readMeta(entry.Name())
+		if err != nil || meta.CreatedAt.Before(deadline) {
+			if err = os.RemoveAll(c.uploadDir(entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *ChunkUploader) loadMeta(ctx *Context) (*chunkMeta, error) {
+	param := c.UploadIDParam
+	if param == "" {
+		param = "id"
+	}
+	id, _ := ctx.PathValue(param).String()
+	// id会拼接到路径里面，必须是Init生成的格式
+	if !uploadIDPattern.MatchString(id) {
+		return nil, ErrUploadNotFound
+	}
+	return c.readMeta(id)
+}
+
+func (c *ChunkUploader) readMeta(id string) (*chunkMeta, error) {
+	data, err := os.ReadFile(filepath.Join(c.uploadDir(id), chunkMetaFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, err
+	}
+	meta := &chunkMeta{}
+	if err = json.Unmarshal(data, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// received 已经收到的分片，Chunk保证了落盘的分片大小都是正确的
+func (c *ChunkUploader) received(meta *chunkMeta) ([]int, error) {
+	entries, err := os.ReadDir(c.uploadDir(meta.ID))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, err
+	}
+	res := make([]int, 0, len(entries))
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), chunkPartSuffix)
+		if !ok {
+			continue
+		}
+		index, err := strconv.Atoi(name)
+		if err != nil || index < 0 || index >= meta.Chunks {
+			continue
+		}
+		res = append(res, index)
+	}
+	sort.Ints(res)
+	return res, nil
+}
+
+func (c *ChunkUploader) status(meta *chunkMeta, received []int) ChunkUploadStatus {
+	if received == nil {
+		received = []int{}
+	}
+	return ChunkUploadStatus{
+		UploadID:  meta.ID,
+		Filename:  meta.Filename,
+		Size:      meta.Size,
+		ChunkSize: meta.ChunkSize,
+		Chunks:    meta.Chunks,
+		Received:  received,
+	}
+}
+
+// chunkReader 按顺序读取所有分片，同一时间只打开一个文件
+type chunkReader struct {
+	paths []string
+	cur   *os.File
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if len(r.paths) == 0 {
+				return 0, io.EOF
+			}
+			file, err := os.Open(r.paths[0])
+			if err != nil {
+				return 0, err
+			}
+			r.cur, r.paths = file, r.paths[1:]
+		}
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			_ = r.cur.Close()
+			r.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.cur == nil {
+		return nil
+	}
+	return r.cur.Close()
+}
@@ -23,6 +23,15 @@ type Context struct {
 	tplEngine TemplateEngine
 	// UserValues的初始化为nil，由用户手动初始化
 	UserValues map[string]any
+
+	// 是否处于流式响应模式，此时响应已经直接写到Resp里面，不再使用RespData
+	streaming     bool
+	streamedBytes int
+	// 底层连接是否已经被接管，例如升级为WebSocket
+	hijacked bool
+
+	// Err 处理请求过程中出现的错误，例如Bind系列方法绑定、校验失败，交给middleware统一处理
+	Err error
 }
 
 func (c *Context) Render(tplName string, data any) error {
@@ -32,12 +41,17 @@ func (c *Context) Render(tplName string, data any) error {
 		c.RespStatusCode = http.StatusInternalServerError
 		return err
 	}
+	c.setContentType(mimeHTML)
 	c.RespStatusCode = http.StatusOK
 	return nil
 }
 
-// BindJSON 解决大多数人的需求即可
+// BindJSON 解决大多数人的需求即可，解析完成之后按照validate tag进行校验
 func (c *Context) BindJSON(val any) error {
+	return c.validate(val, "", c.bindJSON(val))
+}
+
+func (c *Context) bindJSON(val any) error {
 	if val == nil {
 		return errors.New("web：输入不能为nil")
 	}
@@ -136,13 +150,8 @@ func (c *Context) RespJSON(status int, val any) error {
 	if err != nil {
 		return err
 	}
-	c.Resp.WriteHeader(status)
-	/*n, err := c.Resp.Write(data)
-	if n != len(data) {
-		return errors.New("web: 未写入全部数据")
-	}
-	return err
-	*/
+	// 响应码交给flashResp统一写，直接WriteHeader会导致middleware没办法再修改响应头
+	c.setContentType(mimeJSON)
 	c.RespData = data
 	c.RespStatusCode = status
 	return nil
@@ -1,139 +1,426 @@
 package web
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	lru "github.com/hashicorp/golang-lru"
 	"io"
-	"io/ioutil"
+	"io/fs"
 	"log"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
+	"unicode"
 )
 
+var (
+	ErrUploadNoFile         = errors.New("web: 上传失败，未找到数据")
+	ErrUploadTooManyFiles   = errors.New("web: 上传的文件数量超过限制")
+	ErrUploadTooLarge       = errors.New("web: 上传的文件超过大小限制")
+	ErrUploadTypeNotAllowed = errors.New("web: 不允许上传该类型的文件")
+)
+
+// UploadedFile 保存成功的文件
+type UploadedFile struct {
+	// Filename 客户端传过来的原始文件名
+	Filename string `json:"filename"`
+	// Name 保存之后的文件名
+	Name string `json:"name"`
+	// Path 保存的完整路径，不返回给客户端
+	Path        string `json:"-"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	ContentType string `json:"content_type"`
+}
+
 type FileUploader struct {
 	FileField string
-	// 用于计算文件的路径
+	// 用于计算文件的路径，为nil时保存到Dir下面，文件名由随机前缀加上SafeFileName生成
+	// 流式写入时还不知道文件大小，fh.Size总是0
 	DstPathFunc func(fh *multipart.FileHeader) string
+	Dir         string
+	// MaxFileSize 单个文件的大小上限，0表示不限制
+	MaxFileSize int64
+	// MaxFiles 一次请求最多上传的文件数量，0表示不限制
+	MaxFiles int
+	// AllowedExts 允许的扩展名，例如 .jpg、png，不区分大小写，为空表示不限制
+	AllowedExts []string
+	// AllowedMIMETypes 允许的MIME类型，根据文件内容探测而不是客户端声明的Content-Type，支持image/*这种写法
+	AllowedMIMETypes []string
 }
 
 // Handle 文件上传功能的第一种设计实现，优势：支持额外的字段检测
+// 同一个FileField下的多个文件都会被保存，成功后以JSON返回[]UploadedFile，任何一个文件失败都会删除已经保存的文件
 func (f *FileUploader) Handle() HandleFunc {
-	// 这里可做一些额外的检测，～～
 	return func(ctx *Context) {
-		src, srcHeader, err := ctx.Req.FormFile(f.FileField)
+		files, err := f.Upload(ctx)
 		if err != nil {
-			ctx.RespStatusCode = http.StatusBadRequest
-			ctx.RespData = []byte("上传失败，未找到数据")
+			respUploadErr(ctx, err)
 			return
 		}
-		defer src.Close()
+		_ = ctx.RespJSON(http.StatusOK, files)
+	}
+}
 
-		dst, err := os.OpenFile(f.DstPathFunc(srcHeader), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o666)
+// HandleFunc Deprecated 文件上传功能的第二种实现，可直接用来注册路由，此外和Option模式配合的很好
+func (f *FileUploader) HandleFunc(ctx *Context) {
+	f.Handle()(ctx)
+}
+
+// Upload 流式读取请求体，边写磁盘边计算SHA-256，不会把整个文件读到内存或者临时文件里面
+// 如果请求已经被ParseMultipartForm解析过了，例如调用过Bind，就从MultipartForm中读取
+func (f *FileUploader) Upload(ctx *Context) ([]UploadedFile, error) {
+	res := make([]UploadedFile, 0, 4)
+	save := func(fh *multipart.FileHeader, src io.Reader) error {
+		if f.MaxFiles > 0 && len(res) >= f.MaxFiles {
+			return ErrUploadTooManyFiles
+		}
+		file, err := f.save(fh, src)
 		if err != nil {
-			ctx.RespStatusCode = http.StatusInternalServerError
-			ctx.RespData = []byte("上传失败")
-			return
+			return err
 		}
-		defer src.Close()
+		res = append(res, file)
+		return nil
+	}
 
-		_, err = io.CopyBuffer(dst, src, nil)
-		if err != nil {
-			ctx.RespStatusCode = http.StatusInternalServerError
-			ctx.RespData = []byte("上传,拷贝文件失败")
-			return
+	var err error
+	if ctx.Req.MultipartForm != nil {
+		err = f.uploadParsed(ctx.Req.MultipartForm, save)
+	} else {
+		err = f.uploadStream(ctx.Req, save)
+	}
+	if err == nil && len(res) == 0 {
+		err = ErrUploadNoFile
+	}
+	if err != nil {
+		for _, file := range res {
+			_ = os.Remove(file.Path)
 		}
-		defer src.Close()
-		ctx.RespData = []byte("上传成功")
+		return nil, err
 	}
+	return res, nil
 }
 
-// HandleFunc Deprecated 文件上传功能的第二种实现，可直接用来注册路由，此外和Option模式配合的很好
-func (f *FileUploader) HandleFunc(ctx *Context) {
-	src, srcHeader, err := ctx.Req.FormFile(f.FileField)
+func (f *FileUploader) uploadStream(req *http.Request, save func(fh *multipart.FileHeader, src io.Reader) error) error {
+	reader, err := req.MultipartReader()
 	if err != nil {
-		ctx.RespStatusCode = http.StatusBadRequest
-		ctx.RespData = []byte("上传失败，未找到数据")
-		return
+		return fmt.Errorf("%w: %v", ErrUploadNoFile, err)
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if part.FormName() != f.FileField || part.FileName() == "" {
+			_ = part.Close()
+			continue
+		}
+		err = save(&multipart.FileHeader{Filename: part.FileName(), Header: part.Header}, part)
+		_ = part.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (f *FileUploader) uploadParsed(form *multipart.Form, save func(fh *multipart.FileHeader, src io.Reader) error) error {
+	for _, fh := range form.File[f.FileField] {
+		src, err := fh.Open()
+		if err != nil {
+			return err
+		}
+		err = save(fh, src)
+		_ = src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FileUploader) save(fh *multipart.FileHeader, src io.Reader) (UploadedFile, error) {
+	rule := uploadRule{maxSize: f.MaxFileSize, exts: f.AllowedExts, mimeTypes: f.AllowedMIMETypes}
+	if !rule.extAllowed(fh.Filename) {
+		return UploadedFile{}, ErrUploadTypeNotAllowed
+	}
+	var dst string
+	if f.DstPathFunc != nil {
+		dst = f.DstPathFunc(fh)
+	} else {
+		dst = randomFilePath(f.Dir, fh.Filename)
+	}
+	return rule.store(dst, fh.Filename, src)
+}
+
+// uploadRule 普通上传和分片上传共用的校验规则
+type uploadRule struct {
+	maxSize   int64
+	exts      []string
+	mimeTypes []string
+}
+
+func (r uploadRule) extAllowed(name string) bool {
+	if len(r.exts) == 0 {
+		return true
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, allowed := range r.exts {
+		allowed = strings.ToLower(allowed)
+		if !strings.HasPrefix(allowed, ".") {
+			allowed = "." + allowed
+		}
+		if ext == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (r uploadRule) mimeAllowed(contentType string) bool {
+	if len(r.mimeTypes) == 0 {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	for _, allowed := range r.mimeTypes {
+		allowed = strings.ToLower(allowed)
+		if allowed == mediaType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// store 先探测文件类型，再写到dst同目录下的临时文件，写完之后rename，避免别人读到写了一半的文件
+func (r uploadRule) store(dst string, filename string, src io.Reader) (UploadedFile, error) {
+	reader := bufio.NewReaderSize(src, 512)
+	head, err := reader.Peek(512)
+	if err != nil && err != io.EOF {
+		return UploadedFile{}, err
+	}
+	contentType := http.DetectContentType(head)
+	if !r.mimeAllowed(contentType) {
+		return UploadedFile{}, ErrUploadTypeNotAllowed
 	}
-	defer src.Close()
 
-	dst, err := os.OpenFile(f.DstPathFunc(srcHeader), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o666)
+	dir := filepath.Dir(dst)
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return UploadedFile{}, err
+	}
+	tmp, err := os.CreateTemp(dir, ".upload-*")
 	if err != nil {
-		ctx.RespStatusCode = http.StatusInternalServerError
-		ctx.RespData = []byte("上传失败")
-		return
+		return UploadedFile{}, err
 	}
-	defer src.Close()
+	defer func() {
+		// rename成功之后临时文件已经不存在了
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
 
-	_, err = io.CopyBuffer(dst, src, nil)
+	var limited io.Reader = reader
+	if r.maxSize > 0 {
+		limited = io.LimitReader(reader, r.maxSize+1)
+	}
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), limited)
 	if err != nil {
-		ctx.RespStatusCode = http.StatusInternalServerError
-		ctx.RespData = []byte("上传,拷贝文件失败")
-		return
+		return UploadedFile{}, err
+	}
+	if r.maxSize > 0 && size > r.maxSize {
+		return UploadedFile{}, ErrUploadTooLarge
+	}
+	if err = tmp.Close(); err != nil {
+		return UploadedFile{}, err
 	}
-	defer src.Close()
-	ctx.RespData = []byte("上传成功")
+	if err = os.Rename(tmp.Name(), dst); err != nil {
+		return UploadedFile{}, err
+	}
+	return UploadedFile{
+		Filename:    filename,
+		Name:        filepath.Base(dst),
+		Path:        dst,
+		Size:        size,
+		SHA256:      hex.EncodeToString(hasher.Sum(nil)),
+		ContentType: contentType,
+	}, nil
+}
+
+// respUploadErr 根据错误类型选择响应码，其余错误不把细节暴露给客户端
+func respUploadErr(ctx *Context, err error) {
+	switch {
+	case errors.Is(err, ErrUploadTooLarge):
+		_ = ctx.RespString(http.StatusRequestEntityTooLarge, ErrUploadTooLarge.Error())
+	case errors.Is(err, ErrUploadTypeNotAllowed):
+		_ = ctx.RespString(http.StatusUnsupportedMediaType, ErrUploadTypeNotAllowed.Error())
+	case errors.Is(err, ErrUploadNotFound):
+		_ = ctx.RespString(http.StatusNotFound, ErrUploadNotFound.Error())
+	case errors.Is(err, ErrUploadNoFile), errors.Is(err, ErrUploadTooManyFiles),
+		errors.Is(err, ErrUploadInvalidChunk), errors.Is(err, ErrUploadIncomplete),
+		errors.Is(err, ErrUploadChecksumMismatch):
+		_ = ctx.RespString(http.StatusBadRequest, err.Error())
+	default:
+		_ = ctx.RespString(http.StatusInternalServerError, "web: 上传失败")
+	}
+}
+
+// SafeFileName 把客户端传过来的文件名处理成可以直接落盘的名字
+// 去掉路径部分，只保留字母、数字、. - _，去掉开头的.，避免 ../ 和隐藏文件
+func SafeFileName(name string) string {
+	// IE之类的浏览器会把完整路径传过来，例如 C:\Users\tom\a.jpg
+	if index := strings.LastIndexAny(name, `/\`); index >= 0 {
+		name = name[index+1:]
+	}
+	var sb strings.Builder
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '-' || r == '_' {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteRune('_')
+		}
+	}
+	res := strings.TrimLeft(sb.String(), ".")
+	const maxLen = 128
+	if runes := []rune(res); len(runes) > maxLen {
+		ext := []rune(filepath.Ext(res))
+		if len(ext) > 16 {
+			ext = nil
+		}
+		res = string(runes[:maxLen-len(ext)]) + string(ext)
+	}
+	if res == "" {
+		return "file"
+	}
+	return res
+}
+
+// randomFilePath 随机前缀避免同名文件互相覆盖
+func randomFilePath(dir string, filename string) string {
+	return filepath.Join(dir, randomHex(8)+"_"+SafeFileName(filename))
+}
+
+func randomHex(n int) string {
+	bs := make([]byte, n)
+	_, _ = rand.Read(bs)
+	return hex.EncodeToString(bs)
 }
 
 // FileDownloader FileDownloader直接操作http.ResponseWriter，因而middleware不能直接使用RespData
+// 支持If-None-Match、If-Modified-Since条件请求返回304，以及Range、If-Range断点续传
 type FileDownloader struct {
 	Dir string
+	// FS 设置之后从FS中读取文件，忽略Dir，例如embed.FS
+	FS fs.FS
+	// PathParam 从路径参数中读取文件路径，配合 /download/*filepath 这种末尾通配符使用
+	// 为空时从查询参数file中读取
+	PathParam string
+	// CacheControl 为空时使用must-revalidate
+	CacheControl string
 }
 
 // Handle 处理文件下载
 func (f *FileDownloader) Handle() HandleFunc {
+	fsys := f.FS
+	if fsys == nil {
+		fsys = dirFS(f.Dir)
+	}
+	cacheControl := f.CacheControl
+	if cacheControl == "" {
+		cacheControl = "must-revalidate"
+	}
 	return func(ctx *Context) {
-		req, _ := ctx.QueryValue("file").String()
-		path := filepath.Join(f.Dir, filepath.Clean(req))
-		fn := filepath.Base(path)
+		var req string
+		if f.PathParam != "" {
+			req, _ = ctx.PathValue(f.PathParam).String()
+		} else {
+			req, _ = ctx.QueryValue("file").String()
+		}
+		file, info, err := openFile(fsys, req)
+		if err != nil {
+			ctx.RespStatusCode = http.StatusNotFound
+			return
+		}
+		defer file.Close()
+		content, ok := file.(io.ReadSeeker)
+		if !ok {
+			ctx.RespStatusCode = http.StatusInternalServerError
+			return
+		}
+		etag, err := fileETag(info, content)
+		if err != nil {
+			ctx.RespStatusCode = http.StatusInternalServerError
+			return
+		}
 		header := ctx.Resp.Header()
-		header.Set("Content-Disposition", "attachment;filename="+fn)
+		header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": info.Name()}))
 		header.Set("Content-Description", "File Transfer")
 		header.Set("Content-Type", "application/octet-stream")
 		header.Set("Content-Transfer-Encoding", "binary")
-		header.Set("Expires", "0")
-		header.Set("Cache-Control", "must-revalidate")
-		header.Set("Pragma", "public")
-		http.ServeFile(ctx.Resp, ctx.Req, path)
+		header.Set("Cache-Control", cacheControl)
+		header.Set("ETag", etag)
+		ctx.serveContent(info.Name(), info.ModTime(), content)
 	}
 }
 
 type StaticResourceHandler struct {
-	dir                     string
+	fsys fs.FS
+	// 读取文件路径的路径参数名，默认为file
+	pathParam               string
 	extensionContentTypeMap map[string]string
+	cacheControl            string
 	// 缓存静态资源的限制
 	cache       *lru.Cache
 	maxFileSize int
 }
 
 // Handle 处理静态资源，包括缓存文件操作
+// 响应带上ETag和Last-Modified，条件请求返回304，支持Range请求
 func (h *StaticResourceHandler) Handle(ctx *Context) {
-	req, _ := ctx.PathValue("file").String()
-	if item, ok := h.readFileFromData(req); ok {
-		log.Printf("Handle 从缓存中读数据....")
-		h.writeItemAsResponse(item, ctx.Resp)
+	req, _ := ctx.PathValue(h.pathParam).String()
+	file, info, err := openFile(h.fsys, req)
+	if err != nil {
+		if h.cache != nil {
+			h.cache.Remove(req)
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			ctx.RespStatusCode = http.StatusNotFound
+			return
+		}
+		ctx.RespStatusCode = http.StatusInternalServerError
 		return
 	}
+	defer file.Close()
 
-	path := filepath.Join(h.dir, req)
-	file, err := os.Open(path)
-	if err != nil {
-		ctx.Resp.WriteHeader(http.StatusInternalServerError)
+	// 文件修改之后缓存就失效了，不能继续返回旧的内容和ETag
+	if item, ok := h.readFileFromData(req); ok && item.modTime.Equal(info.ModTime()) && int64(item.fileSize) == info.Size() {
+		log.Printf("Handle 从缓存中读数据....")
+		h.writeItemAsResponse(item, ctx)
 		return
 	}
 
-	ext := getFileExt(file.Name())
+	ext := getFileExt(info.Name())
 	cType, ok := h.extensionContentTypeMap[ext]
 	if !ok {
-		ctx.Resp.WriteHeader(http.StatusBadRequest)
+		ctx.RespStatusCode = http.StatusBadRequest
 		return
 	}
-	data, err := ioutil.ReadAll(file)
+	data, err := io.ReadAll(file)
 	if err != nil {
-		ctx.Resp.WriteHeader(http.StatusInternalServerError)
+		ctx.RespStatusCode = http.StatusInternalServerError
 		return
 	}
 	item := &fileCacheItem{
@@ -141,9 +428,11 @@ func (h *StaticResourceHandler) Handle(ctx *Context) {
 		data:        data,
 		contentType: cType,
 		fileName:    req,
+		modTime:     info.ModTime(),
+		etag:        contentETag(data),
 	}
 	h.cacheFile(item)
-	h.writeItemAsResponse(item, ctx.Resp)
+	h.writeItemAsResponse(item, ctx)
 }
 
 // readFileFromData 从cache中根据文件名读数据
@@ -156,12 +445,15 @@ func (h *StaticResourceHandler) readFileFromData(fileName string) (*fileCacheIte
 	return nil, false
 }
 
-// writeItemAsResponse 将静态文件写到响应中
-func (h *StaticResourceHandler) writeItemAsResponse(item *fileCacheItem, writer http.ResponseWriter) {
-	writer.WriteHeader(http.StatusOK)
-	writer.Header().Set("Content-Type", item.contentType)
-	writer.Header().Set("Content-Length", fmt.Sprintf("%d", item.fileSize))
-	_, _ = writer.Write(item.data)
+// writeItemAsResponse 将静态文件写到响应中，响应头必须在写响应码之前设置
+func (h *StaticResourceHandler) writeItemAsResponse(item *fileCacheItem, ctx *Context) {
+	header := ctx.Resp.Header()
+	header.Set("Content-Type", item.contentType)
+	header.Set("ETag", item.etag)
+	if h.cacheControl != "" {
+		header.Set("Cache-Control", h.cacheControl)
+	}
+	ctx.serveContent(item.fileName, item.modTime, bytes.NewReader(item.data))
 }
 
 // cacheFile 缓存静态资源文件
@@ -176,14 +468,22 @@ type fileCacheItem struct {
 	fileSize    int
 	contentType string
 	data        []byte
+	modTime     time.Time
+	etag        string
 }
 
 type StaticResourceHandlerOption func(h *StaticResourceHandler)
 
 func NewStaticResourceHandler(dir string, pathPrefix string,
 	options ...StaticResourceHandlerOption) *StaticResourceHandler {
+	return NewStaticResourceHandlerFS(dirFS(dir), options...)
+}
+
+// NewStaticResourceHandlerFS 从任意的fs.FS中读取静态资源，例如embed.FS
+func NewStaticResourceHandlerFS(fsys fs.FS, options ...StaticResourceHandlerOption) *StaticResourceHandler {
 	resource := &StaticResourceHandler{
-		dir: dir,
+		fsys:      fsys,
+		pathParam: "file",
 		extensionContentTypeMap: map[string]string{
 			// 可根据自己的需要不断添加
 			"jpeg": "image/jpeg",
@@ -212,6 +512,20 @@ func WithFileCache(maxFileSizeThreshold int, maxCacheFileCnt int) StaticResource
 	}
 }
 
+// WithPathParam 指定读取文件路径的路径参数名，例如注册 /static/*filepath 时使用filepath
+func WithPathParam(name string) StaticResourceHandlerOption {
+	return func(h *StaticResourceHandler) {
+		h.pathParam = name
+	}
+}
+
+// WithCacheControl 设置静态资源的Cache-Control，例如 public, max-age=86400
+func WithCacheControl(value string) StaticResourceHandlerOption {
+	return func(h *StaticResourceHandler) {
+		h.cacheControl = value
+	}
+}
+
 // WithMoreExtension 支持更多的文件类型
 func WithMoreExtension(extMap map[string]string) StaticResourceHandlerOption {
 	return func(h *StaticResourceHandler) {
@@ -221,6 +535,58 @@ func WithMoreExtension(extMap map[string]string) StaticResourceHandlerOption {
 	}
 }
 
+// dirFS 空目录表示当前目录，os.DirFS("")会从根目录开始读取
+func dirFS(dir string) fs.FS {
+	if dir == "" {
+		dir = "."
+	}
+	return os.DirFS(dir)
+}
+
+// openFile 按照绝对路径Clean一遍，避免通过 ../ 访问到fsys之外的文件，目录当作不存在
+func openFile(fsys fs.FS, name string) (fs.File, fs.FileInfo, error) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		name = "."
+	}
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, nil, err
+	}
+	if info.IsDir() {
+		_ = file.Close()
+		return nil, nil, fs.ErrNotExist
+	}
+	return file, info, nil
+}
+
+// contentETag 根据文件内容生成强ETag，If-Range只认强ETag
+func contentETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// fileETag 有修改时间的时候使用大小和修改时间，和nginx一样，不需要读取文件
+// embed.FS之类没有修改时间的只能根据内容计算，计算完之后把读取位置恢复到开头
+func fileETag(info fs.FileInfo, content io.ReadSeeker) (string, error) {
+	if !info.ModTime().IsZero() {
+		return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()), nil
+	}
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return `"` + hex.EncodeToString(hasher.Sum(nil)[:16]) + `"`, nil
+}
+
 // 获取文件后缀
 func getFileExt(name string) string {
 	index := strings.LastIndex(name, ".")
@@ -0,0 +1,120 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+)
+
+// RouterGroup 路由分组，同一个分组下的路由共享路径前缀和middleware
+// 分组并不持有自己的路由树，所有路由最终都注册到HTTPServer的router上
+type RouterGroup struct {
+	prefix string
+	mdls   []Middleware
+	server *HTTPServer
+}
+
+// Group 创建一个路由分组，prefix必须以/开头，末尾的/会被去掉，mdls只作用于该分组下注册的路由
+func (h *HTTPServer) Group(prefix string, mdls ...Middleware) *RouterGroup {
+	return &RouterGroup{
+		prefix: normalizeGroupPrefix(prefix),
+		mdls:   mdls,
+		server: h,
+	}
+}
+
+// Group 创建嵌套的子分组，子分组继承父分组的前缀和middleware
+func (g *RouterGroup) Group(prefix string, mdls ...Middleware) *RouterGroup {
+	prefix = normalizeGroupPrefix(prefix)
+	// 复制一份，避免多个子分组共享同一个底层数组
+	groupMdls := make([]Middleware, 0, len(g.mdls)+len(mdls))
+	groupMdls = append(groupMdls, g.mdls...)
+	groupMdls = append(groupMdls, mdls...)
+	return &RouterGroup{
+		prefix: g.joinPath(prefix),
+		mdls:   groupMdls,
+		server: g.server,
+	}
+}
+
+// Use 给分组追加middleware，只影响之后注册的路由
+func (g *RouterGroup) Use(mdls ...Middleware) {
+	g.mdls = append(g.mdls, mdls...)
+}
+
+func (g *RouterGroup) addRoute(method string, path string, handleFunc HandleFunc) {
+	g.server.addRoute(method, g.joinPath(path), g.wrap(handleFunc))
+}
+
+// wrap 分组的middleware在注册时就包裹在handleFunc外层，从后往前构造链条
+func (g *RouterGroup) wrap(handleFunc HandleFunc) HandleFunc {
+	root := handleFunc
+	for i := len(g.mdls) - 1; i >= 0; i-- {
+		root = g.mdls[i](root)
+	}
+	return root
+}
+
+// joinPath 拼接分组前缀和路由，path为/时代表分组前缀本身
+// path必须以/开头，否则Group("/api")下的GET("user")会变成/apiuser
+func (g *RouterGroup) joinPath(path string) string {
+	if path == "" || path[0] != '/' {
+		panic("web: 路径必须以/为开头")
+	}
+	if g.prefix == "/" {
+		return path
+	}
+	if path == "/" {
+		return g.prefix
+	}
+	return g.prefix + path
+}
+
+// GET 请求，在RouterGroup中实现
+func (g *RouterGroup) GET(path string, handleFunc HandleFunc) {
+	g.addRoute(http.MethodGet, path, handleFunc)
+}
+
+// POST 请求，在RouterGroup中实现
+func (g *RouterGroup) POST(path string, handleFunc HandleFunc) {
+	g.addRoute(http.MethodPost, path, handleFunc)
+}
+
+func (g *RouterGroup) OPTIONS(path string, handleFunc HandleFunc) {
+	g.addRoute(http.MethodOptions, path, handleFunc)
+}
+
+func (g *RouterGroup) PUT(path string, handleFunc HandleFunc) {
+	g.addRoute(http.MethodPut, path, handleFunc)
+}
+
+func (g *RouterGroup) DELETE(path string, handleFunc HandleFunc) {
+	g.addRoute(http.MethodDelete, path, handleFunc)
+}
+
+func (g *RouterGroup) PATCH(path string, handleFunc HandleFunc) {
+	g.addRoute(http.MethodPatch, path, handleFunc)
+}
+
+func (g *RouterGroup) HEAD(path string, handleFunc HandleFunc) {
+	g.addRoute(http.MethodHead, path, handleFunc)
+}
+
+// Any 在所有常用的http方法上注册同一个handleFunc，分组的middleware只包裹一次
+func (g *RouterGroup) Any(path string, handleFunc HandleFunc) {
+	root := g.wrap(handleFunc)
+	for _, method := range anyMethods {
+		g.server.addRoute(method, g.joinPath(path), root)
+	}
+}
+
+// normalizeGroupPrefix 分组前缀必须以/开头，去掉末尾的/，只剩下/时就是根分组
+func normalizeGroupPrefix(prefix string) string {
+	if prefix == "" || prefix[0] != '/' {
+		panic("web: 分组前缀必须以/为开头")
+	}
+	prefix = strings.TrimRight(prefix, "/")
+	if prefix == "" {
+		return "/"
+	}
+	return prefix
+}
@@ -0,0 +1,119 @@
+package web
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouterGroup(t *testing.T) {
+	var logs []string
+	mdlBuilder := func(name string) Middleware {
+		return func(next HandleFunc) HandleFunc {
+			return func(ctx *Context) {
+				logs = append(logs, name)
+				next(ctx)
+			}
+		}
+	}
+	var mockHandler HandleFunc = func(ctx *Context) {
+		ctx.RespData = []byte(ctx.MatchedRoute)
+	}
+
+	server := NewHTTPServer()
+	server.GET("/home", mockHandler)
+	api := server.Group("/api", mdlBuilder("api"))
+	v1 := api.Group("/v1", mdlBuilder("v1"))
+	v1.GET("/user", mockHandler)
+	admin := v1.Group("/admin", mdlBuilder("admin"))
+	admin.GET("/", mockHandler)
+	admin.POST("/user", mockHandler)
+
+	testCases := []struct {
+		name     string
+		method   string
+		path     string
+		wantLogs []string
+		wantData string
+	}{
+		{
+			name:     "no group",
+			method:   http.MethodGet,
+			path:     "/home",
+			wantData: "home",
+		},
+		{
+			name:     "nested group",
+			method:   http.MethodGet,
+			path:     "/api/v1/user",
+			wantLogs: []string{"api", "v1"},
+			wantData: "api/v1/user",
+		},
+		{
+			name:     "group root",
+			method:   http.MethodGet,
+			path:     "/api/v1/admin",
+			wantLogs: []string{"api", "v1", "admin"},
+			wantData: "api/v1/admin",
+		},
+		{
+			name:     "deep nested group",
+			method:   http.MethodPost,
+			path:     "/api/v1/admin/user",
+			wantLogs: []string{"api", "v1", "admin"},
+			wantData: "api/v1/admin/user",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			logs = nil
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantLogs, logs)
+			assert.Equal(t, tc.wantData, recorder.Body.String())
+		})
+	}
+
+	assert.Panics(t, func() {
+		server.Group("api")
+	})
+	// 路由没有以/开头不能直接拼接到前缀上
+	assert.Panics(t, func() {
+		api.GET("user", mockHandler)
+	})
+	assert.Panics(t, func() {
+		api.GET("", mockHandler)
+	})
+}
+
+func TestRouterGroup_normalizePrefix(t *testing.T) {
+	var mockHandler HandleFunc = func(ctx *Context) {
+		ctx.RespData = []byte(ctx.MatchedRoute)
+	}
+	server := NewHTTPServer()
+	server.Group("/api/").GET("/user", mockHandler)
+	root := server.Group("/")
+	root.GET("/home", mockHandler)
+	root.Group("/v1/").Group("/").GET("/", mockHandler)
+
+	testCases := []struct {
+		name     string
+		path     string
+		wantData string
+	}{
+		{name: "trailing slash", path: "/api/user", wantData: "api/user"},
+		{name: "root group", path: "/home", wantData: "home"},
+		{name: "nested root group", path: "/v1", wantData: "v1"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			assert.Equal(t, http.StatusOK, recorder.Code)
+			assert.Equal(t, tc.wantData, recorder.Body.String())
+		})
+	}
+}
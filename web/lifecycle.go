@@ -0,0 +1,100 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// Hook 生命周期回调，通过ctx控制超时
+type Hook func(ctx context.Context) error
+
+// ServerWithStartHooks 开始监听之后、接收请求之前执行，任何一个返回error都会导致启动失败
+func ServerWithStartHooks(hooks ...Hook) HTTPServerOption {
+	return func(server *HTTPServer) {
+		server.startHooks = append(server.startHooks, hooks...)
+	}
+}
+
+// ServerWithStopHooks 已有的请求处理完毕之后执行，按照注册的顺序执行，适合释放资源
+func ServerWithStopHooks(hooks ...Hook) HTTPServerOption {
+	return func(server *HTTPServer) {
+		server.stopHooks = append(server.stopHooks, hooks...)
+	}
+}
+
+// Serve 在listener上接收请求，和http.Server.Serve一样，Shutdown之后返回http.ErrServerClosed
+func (h *HTTPServer) Serve(listener net.Listener) error {
+	srv, err := h.prepare(listener)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(listener)
+}
+
+// prepare 创建底层的http.Server，并且执行启动回调
+func (h *HTTPServer) prepare(listener net.Listener) (*http.Server, error) {
+	h.mutex.Lock()
+	if h.server != nil {
+		h.mutex.Unlock()
+		_ = listener.Close()
+		return nil, errors.New("web: 服务器已经启动")
+	}
+	srv := h.newServer()
+	h.server = srv
+	h.mutex.Unlock()
+
+	for _, hook := range h.startHooks {
+		if err := hook(context.Background()); err != nil {
+			_ = listener.Close()
+			// 启动失败，允许修复之后重新启动
+			h.mutex.Lock()
+			h.server = nil
+			h.mutex.Unlock()
+			return nil, err
+		}
+	}
+	return srv, nil
+}
+
+// Drain 进入draining状态，之后新的请求直接返回503
+// 一般在Shutdown之前调用，让负载均衡有时间把流量摘掉，同时已有的请求可以继续处理
+func (h *HTTPServer) Drain() {
+	h.draining.Store(true)
+}
+
+// Draining 是否处于draining状态
+func (h *HTTPServer) Draining() bool {
+	return h.draining.Load()
+}
+
+// Shutdown 优雅退出：进入draining状态，关闭监听，等待已有的请求处理完毕，最后执行关闭回调
+// ctx超时的时候不再等待剩下的请求，但是依旧会执行关闭回调
+func (h *HTTPServer) Shutdown(ctx context.Context) error {
+	h.Drain()
+	h.mutex.Lock()
+	srv := h.server
+	h.mutex.Unlock()
+
+	var errs []error
+	if srv != nil {
+		if err := srv.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, hook := range h.stopHooks {
+		if err := hook(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// serveDraining draining状态下代替路由处理请求，依旧经过全局middleware，access log、监控能记录到503
+// Connection: close让客户端不要复用这个连接
+func (h *HTTPServer) serveDraining(ctx *Context) {
+	ctx.Resp.Header().Set("Connection", "close")
+	ctx.RespStatusCode = http.StatusServiceUnavailable
+	ctx.RespData = []byte("server is shutting down")
+}
@@ -0,0 +1,99 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPServer_Shutdown(t *testing.T) {
+	var events []string
+	var codes []int
+	server := NewHTTPServer(
+		ServerWithMiddleware(func(next HandleFunc) HandleFunc {
+			return func(ctx *Context) {
+				next(ctx)
+				codes = append(codes, ctx.RespStatusCode)
+			}
+		}),
+		ServerWithStartHooks(func(ctx context.Context) error {
+			events = append(events, "start")
+			return nil
+		}),
+		ServerWithStopHooks(func(ctx context.Context) error {
+			events = append(events, "stop")
+			return nil
+		}))
+	started := make(chan struct{})
+	server.GET("/slow", func(ctx *Context) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		ctx.RespData = []byte("done")
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	type result struct {
+		body string
+		err  error
+	}
+	respCh := make(chan result, 1)
+	go func() {
+		resp, er := http.Get("http://" + listener.Addr().String() + "/slow")
+		if er != nil {
+			respCh <- result{err: er}
+			return
+		}
+		defer resp.Body.Close()
+		body, er := io.ReadAll(resp.Body)
+		respCh <- result{body: string(body), err: er}
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, server.Shutdown(ctx))
+	// 正在处理的请求不会被中断
+	res := <-respCh
+	require.NoError(t, res.err)
+	assert.Equal(t, "done", res.body)
+	assert.Equal(t, http.ErrServerClosed, <-serveErr)
+	assert.Equal(t, []string{"start", "stop"}, events)
+
+	// draining状态下新的请求返回503
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, "close", recorder.Header().Get("Connection"))
+	// 503同样经过全局middleware
+	assert.Equal(t, []int{0, http.StatusServiceUnavailable}, codes)
+}
+
+func TestHTTPServer_StartHookError(t *testing.T) {
+	hookErr := errors.New("mock error")
+	server := NewHTTPServer(ServerWithStartHooks(func(ctx context.Context) error {
+		return hookErr
+	}))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	assert.Equal(t, hookErr, server.Serve(listener))
+	// 启动失败的时候listener已经被关闭
+	_, err = listener.Accept()
+	assert.Error(t, err)
+	// 启动失败之后不会被当作已经启动
+	listener, err = net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	assert.Equal(t, hookErr, server.Serve(listener))
+}
@@ -23,6 +23,8 @@ func (m MiddlewareBuilder) Build() web.Middleware {
 					Route:      ctx.MatchedRoute,
 					HTTPMethod: ctx.Req.Method,
 					Path:       ctx.Req.URL.Path,
+					Status:     ctx.RespStatusCode,
+					Bytes:      ctx.RespSize(),
 				}
 				data, _ := json.Marshal(accessLog)
 				m.logFunc(string(data))
@@ -38,4 +40,7 @@ type accessLog struct {
 	Route      string `json:"route,omitempty"`
 	HTTPMethod string `json:"http_method,omitempty"`
 	Path       string `json:"path,omitempty"`
+	Status     int    `json:"status,omitempty"`
+	// 响应体的大小，流式响应时是实际写出去的字节数
+	Bytes int `json:"bytes,omitempty"`
 }
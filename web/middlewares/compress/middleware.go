@@ -0,0 +1,144 @@
+package compress
+
+import (
+	"github.com/dongma/imola/micro/rpc/compresser"
+	"github.com/dongma/imola/micro/rpc/compresser/deflate"
+	"github.com/dongma/imola/micro/rpc/compresser/gzip"
+	"github.com/dongma/imola/web"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// MiddlewareBuilder 按照Accept-Encoding压缩RespData
+// 压缩之后其它middleware不应该再修改RespData，所以应该放在靠前（外层）的位置
+type MiddlewareBuilder struct {
+	registry *compresser.Registry
+	// 小于minSize的响应不压缩，压缩的收益不够
+	minSize int
+	// 可以压缩的Content-Type，以/结尾的表示前缀匹配，例如text/
+	contentTypes []string
+}
+
+// NewMiddlewareBuilder registry为nil时使用gzip和deflate，优先使用gzip
+func NewMiddlewareBuilder(registry *compresser.Registry) *MiddlewareBuilder {
+	if registry == nil {
+		registry = compresser.NewRegistry()
+		registry.Register("gzip", gzip.GzipCompresser{})
+		registry.Register("deflate", deflate.DeflateCompresser{})
+	}
+	return &MiddlewareBuilder{
+		registry: registry,
+		minSize:  1024,
+		contentTypes: []string{
+			"text/",
+			"application/json",
+			"application/javascript",
+			"application/xml",
+			"application/problem+json",
+			"image/svg+xml",
+		},
+	}
+}
+
+// MinSize 响应体达到size字节才压缩
+func (m *MiddlewareBuilder) MinSize(size int) *MiddlewareBuilder {
+	m.minSize = size
+	return m
+}
+
+// ContentTypes 替换可以压缩的Content-Type
+func (m *MiddlewareBuilder) ContentTypes(types ...string) *MiddlewareBuilder {
+	m.contentTypes = types
+	return m
+}
+
+func (m MiddlewareBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			next(ctx)
+			m.compress(ctx)
+		}
+	}
+}
+
+func (m MiddlewareBuilder) compress(ctx *web.Context) {
+	header := ctx.Resp.Header()
+	if len(ctx.RespData) < m.minSize || header.Get("Content-Encoding") != "" ||
+		ctx.RespStatusCode == http.StatusNoContent || ctx.RespStatusCode == http.StatusNotModified {
+		return
+	}
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(ctx.RespData)
+	}
+	if !m.compressible(contentType) {
+		return
+	}
+	// 响应内容取决于Accept-Encoding，缓存需要区分
+	header.Add("Vary", "Accept-Encoding")
+	name, c, ok := m.negotiate(ctx.Req.Header.Get("Accept-Encoding"))
+	if !ok {
+		return
+	}
+	data, err := c.Compress(ctx.RespData)
+	if err != nil {
+		return
+	}
+	header.Set("Content-Encoding", name)
+	header.Del("Content-Length")
+	ctx.RespData = data
+}
+
+func (m MiddlewareBuilder) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range m.contentTypes {
+		if strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t) || mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiate 选择q值最大的编码，q值相同时按照registry中注册的顺序
+func (m MiddlewareBuilder) negotiate(acceptEncoding string) (string, compresser.Compresser, bool) {
+	if acceptEncoding == "" {
+		return "", nil, false
+	}
+	accepted := make(map[string]float64, 4)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			val, err := strconv.ParseFloat(params[2:], 64)
+			if err != nil {
+				continue
+			}
+			q = val
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+
+	var (
+		bestName string
+		bestQ    float64
+	)
+	for _, name := range m.registry.Names() {
+		q, ok := accepted[name]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+		if ok && q > bestQ {
+			bestName, bestQ = name, q
+		}
+	}
+	if bestName == "" {
+		return "", nil, false
+	}
+	c, ok := m.registry.GetByName(bestName)
+	return bestName, c, ok
+}
@@ -0,0 +1,108 @@
+package compress
+
+import (
+	"github.com/dongma/imola/micro/rpc/compresser/deflate"
+	"github.com/dongma/imola/micro/rpc/compresser/gzip"
+	"github.com/dongma/imola/web"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	builder := NewMiddlewareBuilder(nil).MinSize(16)
+	server := web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
+	largeJSON := `{"name":"` + strings.Repeat("tom", 20) + `"}`
+	server.GET("/json", func(ctx *web.Context) {
+		_ = ctx.RespJSONOK(map[string]string{"name": strings.Repeat("tom", 20)})
+		ctx.Resp.Header().Set("Content-Type", "application/json")
+	})
+	server.GET("/small", func(ctx *web.Context) {
+		ctx.RespData = []byte("small")
+	})
+	server.GET("/png", func(ctx *web.Context) {
+		ctx.Resp.Header().Set("Content-Type", "image/png")
+		ctx.RespData = []byte(strings.Repeat("p", 64))
+	})
+	server.GET("/html", func(ctx *web.Context) {
+		// 没有Content-Type时自动检测
+		ctx.RespData = []byte("<html><body>" + strings.Repeat("hello", 10) + "</body></html>")
+	})
+
+	testCases := []struct {
+		name           string
+		path           string
+		acceptEncoding string
+		wantEncoding   string
+		wantVary       string
+		wantBody       string
+	}{
+		{
+			name:           "gzip",
+			path:           "/json",
+			acceptEncoding: "gzip, deflate",
+			wantEncoding:   "gzip",
+			wantVary:       "Accept-Encoding",
+			wantBody:       largeJSON,
+		},
+		{
+			name:           "deflate with higher q",
+			path:           "/json",
+			acceptEncoding: "gzip;q=0.5, deflate",
+			wantEncoding:   "deflate",
+			wantVary:       "Accept-Encoding",
+			wantBody:       largeJSON,
+		},
+		{
+			name:           "wildcard",
+			path:           "/html",
+			acceptEncoding: "*",
+			wantEncoding:   "gzip",
+			wantVary:       "Accept-Encoding",
+			wantBody:       "<html><body>" + strings.Repeat("hello", 10) + "</body></html>",
+		},
+		{
+			name:           "not accepted",
+			path:           "/json",
+			acceptEncoding: "br, gzip;q=0",
+			wantVary:       "Accept-Encoding",
+			wantBody:       largeJSON,
+		},
+		{
+			name:           "too small",
+			path:           "/small",
+			acceptEncoding: "gzip",
+			wantBody:       "small",
+		},
+		{
+			name:           "not compressible",
+			path:           "/png",
+			acceptEncoding: "gzip",
+			wantBody:       strings.Repeat("p", 64),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantEncoding, recorder.Header().Get("Content-Encoding"))
+			assert.Equal(t, tc.wantVary, recorder.Header().Get("Vary"))
+			body := recorder.Body.Bytes()
+			var err error
+			switch tc.wantEncoding {
+			case "gzip":
+				body, err = gzip.GzipCompresser{}.UnCompress(body)
+			case "deflate":
+				body, err = deflate.DeflateCompresser{}.UnCompress(body)
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantBody, string(body))
+		})
+	}
+}
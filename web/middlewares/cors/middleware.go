@@ -0,0 +1,233 @@
+package cors
+
+import (
+	"github.com/dongma/imola/web"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// MiddlewareBuilder 处理跨域请求，需要通过ServerWithMiddleware注册为全局的middleware
+// 全局middleware在路由匹配之前执行，所以即使没有注册OPTIONS路由，预检请求也能直接在这里返回
+type MiddlewareBuilder struct {
+	// 精确匹配的origin，*表示允许所有的origin
+	origins map[string]struct{}
+	// 通配子域名，例如*.example.com保存为.example.com
+	wildcardOrigins []string
+	allowOriginFunc func(origin string) bool
+	allowAll        bool
+
+	methods          []string
+	headers          []string
+	exposeHeaders    []string
+	allowCredentials bool
+	// 预检请求结果的缓存时间，单位是秒，0表示不设置
+	maxAge int
+}
+
+func NewMiddlewareBuilder() *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		origins: make(map[string]struct{}),
+		methods: []string{http.MethodGet, http.MethodPost, http.MethodPut,
+			http.MethodPatch, http.MethodDelete, http.MethodHead},
+	}
+}
+
+// AllowOrigins 允许的origin，支持精确匹配、*以及*.example.com这种通配子域名
+func (m *MiddlewareBuilder) AllowOrigins(origins ...string) *MiddlewareBuilder {
+	for _, origin := range origins {
+		origin = strings.ToLower(origin)
+		switch {
+		case origin == "*":
+			m.allowAll = true
+		case strings.HasPrefix(origin, "*."):
+			m.wildcardOrigins = append(m.wildcardOrigins, origin[1:])
+		case strings.Contains(origin, "://*."):
+			// https://*.example.com，同时限制scheme
+			m.wildcardOrigins = append(m.wildcardOrigins, strings.Replace(origin, "://*.", "://.", 1))
+		default:
+			m.origins[origin] = struct{}{}
+		}
+	}
+	return m
+}
+
+// AllowOriginFunc 自定义origin校验，和AllowOrigins是或的关系
+func (m *MiddlewareBuilder) AllowOriginFunc(fn func(origin string) bool) *MiddlewareBuilder {
+	m.allowOriginFunc = fn
+	return m
+}
+
+// AllowMethods 替换允许的方法
+func (m *MiddlewareBuilder) AllowMethods(methods ...string) *MiddlewareBuilder {
+	m.methods = make([]string, 0, len(methods))
+	for _, method := range methods {
+		m.methods = append(m.methods, strings.ToUpper(method))
+	}
+	return m
+}
+
+// AllowHeaders 允许的请求头，没有设置时预检请求中的Access-Control-Request-Headers会原样返回
+func (m *MiddlewareBuilder) AllowHeaders(headers ...string) *MiddlewareBuilder {
+	for _, header := range headers {
+		m.headers = append(m.headers, http.CanonicalHeaderKey(header))
+	}
+	return m
+}
+
+// ExposeHeaders 允许浏览器读取的响应头
+func (m *MiddlewareBuilder) ExposeHeaders(headers ...string) *MiddlewareBuilder {
+	m.exposeHeaders = append(m.exposeHeaders, headers...)
+	return m
+}
+
+// AllowCredentials 允许携带cookie，会返回请求中的origin，不能和AllowOrigins("*")一起使用
+func (m *MiddlewareBuilder) AllowCredentials() *MiddlewareBuilder {
+	m.allowCredentials = true
+	return m
+}
+
+// MaxAge 预检请求结果的缓存时间，单位是秒
+func (m *MiddlewareBuilder) MaxAge(seconds int) *MiddlewareBuilder {
+	m.maxAge = seconds
+	return m
+}
+
+// Build 允许所有origin的同时允许携带cookie，等于任何网站都能以用户的身份发请求，这种配置直接panic
+func (m MiddlewareBuilder) Build() web.Middleware {
+	if m.allowAll && m.allowCredentials {
+		panic("cors: AllowOrigins(\"*\") 不能和 AllowCredentials 一起使用")
+	}
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			origin := ctx.Req.Header.Get("Origin")
+			if origin == "" {
+				// 不是跨域请求
+				next(ctx)
+				return
+			}
+			header := ctx.Resp.Header()
+			header.Add("Vary", "Origin")
+			preflight := ctx.Req.Method == http.MethodOptions &&
+				ctx.Req.Header.Get("Access-Control-Request-Method") != ""
+			if preflight {
+				header.Add("Vary", "Access-Control-Request-Method")
+				header.Add("Vary", "Access-Control-Request-Headers")
+				m.handlePreflight(ctx, origin)
+				return
+			}
+			if m.allowOrigin(origin) {
+				m.setOrigin(header, origin)
+				if len(m.exposeHeaders) > 0 {
+					header.Set("Access-Control-Expose-Headers", strings.Join(m.exposeHeaders, ", "))
+				}
+			}
+			next(ctx)
+		}
+	}
+}
+
+// handlePreflight 预检请求不会交给后面的handler，不允许的请求同样返回204，只是不带CORS响应头，由浏览器拒绝
+func (m MiddlewareBuilder) handlePreflight(ctx *web.Context, origin string) {
+	ctx.RespStatusCode = http.StatusNoContent
+	if !m.allowOrigin(origin) {
+		return
+	}
+	method := strings.ToUpper(ctx.Req.Header.Get("Access-Control-Request-Method"))
+	if !m.allowMethod(method) {
+		return
+	}
+	reqHeaders := ctx.Req.Header.Get("Access-Control-Request-Headers")
+	if !m.allowHeaders(reqHeaders) {
+		return
+	}
+
+	header := ctx.Resp.Header()
+	m.setOrigin(header, origin)
+	header.Set("Access-Control-Allow-Methods", strings.Join(m.methods, ", "))
+	if len(m.headers) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(m.headers, ", "))
+	} else if reqHeaders != "" {
+		header.Set("Access-Control-Allow-Headers", reqHeaders)
+	}
+	if m.maxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(m.maxAge))
+	}
+}
+
+func (m MiddlewareBuilder) setOrigin(header http.Header, origin string) {
+	if m.allowAll {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if m.allowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (m MiddlewareBuilder) allowOrigin(origin string) bool {
+	if m.allowAll {
+		return true
+	}
+	lower := strings.ToLower(origin)
+	if _, ok := m.origins[lower]; ok {
+		return true
+	}
+	for _, wildcard := range m.wildcardOrigins {
+		if m.matchWildcard(lower, wildcard) {
+			return true
+		}
+	}
+	return m.allowOriginFunc != nil && m.allowOriginFunc(origin)
+}
+
+// matchWildcard wildcard是.example.com或者https://.example.com，只匹配子域名，不匹配example.com本身
+func (m MiddlewareBuilder) matchWildcard(origin string, wildcard string) bool {
+	if scheme, suffix, ok := strings.Cut(wildcard, "://"); ok {
+		rest, found := strings.CutPrefix(origin, scheme+"://")
+		if !found {
+			return false
+		}
+		origin, wildcard = rest, suffix
+	} else if _, host, found := strings.Cut(origin, "://"); found {
+		origin = host
+	}
+	// 通配没有限制端口时去掉端口，https://a.example.com:8443 同样是 .example.com 的子域名
+	if i := strings.LastIndexByte(origin, ':'); i >= 0 && !strings.Contains(wildcard, ":") {
+		origin = origin[:i]
+	}
+	return strings.HasSuffix(origin, wildcard) && len(origin) > len(wildcard)
+}
+
+func (m MiddlewareBuilder) allowMethod(method string) bool {
+	for _, allowed := range m.methods {
+		if allowed == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (m MiddlewareBuilder) allowHeaders(reqHeaders string) bool {
+	if len(m.headers) == 0 || reqHeaders == "" {
+		return true
+	}
+	for _, h := range strings.Split(reqHeaders, ",") {
+		h = http.CanonicalHeaderKey(strings.TrimSpace(h))
+		if h == "" {
+			continue
+		}
+		found := false
+		for _, allowed := range m.headers {
+			if allowed == h {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,157 @@
+package cors
+
+import (
+	"github.com/dongma/imola/web"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	builder := NewMiddlewareBuilder().
+		AllowOrigins("https://a.com", "*.example.com").
+		AllowOriginFunc(func(origin string) bool {
+			return origin == "http://localhost:3000"
+		}).
+		AllowMethods(http.MethodGet, http.MethodPost).
+		AllowHeaders("Content-Type", "X-Token").
+		ExposeHeaders("X-Request-Id").
+		AllowCredentials().
+		MaxAge(600)
+	server := web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
+	// 没有注册OPTIONS路由
+	server.POST("/user", func(ctx *web.Context) {
+		_ = ctx.RespJSONOK("ok")
+	})
+
+	testCases := []struct {
+		name       string
+		method     string
+		origin     string
+		reqMethod  string
+		reqHeaders string
+
+		wantCode    int
+		wantOrigin  string
+		wantMethods string
+		wantHeaders string
+		wantMaxAge  string
+		wantExpose  string
+	}{
+		{
+			name:        "preflight",
+			method:      http.MethodOptions,
+			origin:      "https://a.com",
+			reqMethod:   http.MethodPost,
+			reqHeaders:  "content-type, x-token",
+			wantCode:    http.StatusNoContent,
+			wantOrigin:  "https://a.com",
+			wantMethods: "GET, POST",
+			wantHeaders: "Content-Type, X-Token",
+			wantMaxAge:  "600",
+		},
+		{
+			name:        "preflight wildcard subdomain",
+			method:      http.MethodOptions,
+			origin:      "https://api.example.com",
+			reqMethod:   http.MethodPost,
+			wantCode:    http.StatusNoContent,
+			wantOrigin:  "https://api.example.com",
+			wantMethods: "GET, POST",
+			wantHeaders: "Content-Type, X-Token",
+			wantMaxAge:  "600",
+		},
+		{
+			name:        "preflight wildcard subdomain with port",
+			method:      http.MethodOptions,
+			origin:      "https://a.example.com:8443",
+			reqMethod:   http.MethodPost,
+			wantCode:    http.StatusNoContent,
+			wantOrigin:  "https://a.example.com:8443",
+			wantMethods: "GET, POST",
+			wantHeaders: "Content-Type, X-Token",
+			wantMaxAge:  "600",
+		},
+		{
+			name:      "preflight wildcard not match root",
+			method:    http.MethodOptions,
+			origin:    "https://example.com",
+			reqMethod: http.MethodPost,
+			wantCode:  http.StatusNoContent,
+		},
+		{
+			name:      "preflight method not allowed",
+			method:    http.MethodOptions,
+			origin:    "https://a.com",
+			reqMethod: http.MethodDelete,
+			wantCode:  http.StatusNoContent,
+		},
+		{
+			name:       "preflight header not allowed",
+			method:     http.MethodOptions,
+			origin:     "https://a.com",
+			reqMethod:  http.MethodPost,
+			reqHeaders: "X-Other",
+			wantCode:   http.StatusNoContent,
+		},
+		{
+			name:       "simple request",
+			method:     http.MethodPost,
+			origin:     "http://localhost:3000",
+			wantCode:   http.StatusOK,
+			wantOrigin: "http://localhost:3000",
+			wantExpose: "X-Request-Id",
+		},
+		{
+			name:     "origin not allowed",
+			method:   http.MethodPost,
+			origin:   "https://b.com",
+			wantCode: http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/user", nil)
+			req.Header.Set("Origin", tc.origin)
+			if tc.reqMethod != "" {
+				req.Header.Set("Access-Control-Request-Method", tc.reqMethod)
+			}
+			if tc.reqHeaders != "" {
+				req.Header.Set("Access-Control-Request-Headers", tc.reqHeaders)
+			}
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			header := recorder.Header()
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantOrigin, header.Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, tc.wantMethods, header.Get("Access-Control-Allow-Methods"))
+			assert.Equal(t, tc.wantHeaders, header.Get("Access-Control-Allow-Headers"))
+			assert.Equal(t, tc.wantMaxAge, header.Get("Access-Control-Max-Age"))
+			assert.Equal(t, tc.wantExpose, header.Get("Access-Control-Expose-Headers"))
+			if tc.wantOrigin != "" {
+				assert.Equal(t, "true", header.Get("Access-Control-Allow-Credentials"))
+			}
+		})
+	}
+}
+
+func TestMiddlewareBuilder_AllowAll(t *testing.T) {
+	server := web.NewHTTPServer(web.ServerWithMiddleware(NewMiddlewareBuilder().AllowOrigins("*").Build()))
+	server.GET("/user", func(ctx *web.Context) {
+		_ = ctx.RespJSONOK("ok")
+	})
+	req := httptest.NewRequest(http.MethodGet, "/user", nil)
+	req.Header.Set("Origin", "https://any.com")
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, "*", recorder.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Origin", recorder.Header().Get("Vary"))
+}
+
+func TestMiddlewareBuilder_AllowAllWithCredentials(t *testing.T) {
+	assert.Panics(t, func() {
+		NewMiddlewareBuilder().AllowOrigins("*").AllowCredentials().Build()
+	})
+}
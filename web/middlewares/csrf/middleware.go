@@ -0,0 +1,190 @@
+package csrf
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"github.com/dongma/imola/web"
+	"github.com/dongma/imola/web/session"
+	"github.com/dongma/imola/web/session/cookie"
+	"html/template"
+	"net/http"
+)
+
+// token和表单字段名在ctx.UserValues中的key
+const (
+	tokenCtxKey = "_csrf_token"
+	fieldCtxKey = "_csrf_field"
+)
+
+// MiddlewareBuilder 防御跨站请求伪造，GET、HEAD、OPTIONS、TRACE以外的请求需要带上token
+// 有两种模式：
+//   - NewMiddlewareBuilder 同步器token，token保存在session里面，需要放在session middleware之后
+//   - NewDoubleSubmitBuilder 双重提交cookie，token保存在cookie里面，适合没有session的无状态服务
+type MiddlewareBuilder struct {
+	// 同步器token模式
+	manager    *session.Manager
+	sessionKey string
+	// 双重提交cookie模式
+	propagator *cookie.Propagator
+
+	fieldName  string
+	headerName string
+	// 校验失败时的处理，默认返回403
+	errHandler web.HandleFunc
+}
+
+// NewMiddlewareBuilder token保存在manager管理的session中，没有session的请求无法通过校验
+func NewMiddlewareBuilder(manager *session.Manager) *MiddlewareBuilder {
+	res := newBuilder()
+	res.manager = manager
+	return res
+}
+
+// NewDoubleSubmitBuilder token保存在cookieName这个cookie中，提交的时候需要同时带上cookie和表单字段（或者请求头）
+// 需要通过请求头提交时，cookie不能设置HttpOnly，否则前端读取不到
+func NewDoubleSubmitBuilder(cookieName string, opts ...cookie.PropagatorOption) *MiddlewareBuilder {
+	res := newBuilder()
+	res.propagator = cookie.NewPropagator(cookieName, opts...)
+	return res
+}
+
+func newBuilder() *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		sessionKey: "_csrf",
+		fieldName:  "csrf_token",
+		headerName: "X-CSRF-Token",
+		errHandler: func(ctx *web.Context) {
+			ctx.RespStatusCode = http.StatusForbidden
+			ctx.RespData = []byte("invalid csrf token")
+		},
+	}
+}
+
+// FieldName 表单字段的名字，默认是csrf_token
+func (m *MiddlewareBuilder) FieldName(name string) *MiddlewareBuilder {
+	m.fieldName = name
+	return m
+}
+
+// HeaderName 请求头的名字，默认是X-CSRF-Token
+func (m *MiddlewareBuilder) HeaderName(name string) *MiddlewareBuilder {
+	m.headerName = name
+	return m
+}
+
+// ErrHandler 校验失败时的处理
+func (m *MiddlewareBuilder) ErrHandler(hdl web.HandleFunc) *MiddlewareBuilder {
+	m.errHandler = hdl
+	return m
+}
+
+func (m MiddlewareBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			token, err := m.token(ctx)
+			if !isSafeMethod(ctx.Req.Method) && (err != nil || !m.valid(ctx, token)) {
+				m.errHandler(ctx)
+				return
+			}
+			if token != "" {
+				m.expose(ctx, token)
+			}
+			next(ctx)
+		}
+	}
+}
+
+// token 拿到当前的token，没有的时候生成一个新的
+func (m MiddlewareBuilder) token(ctx *web.Context) (string, error) {
+	if m.propagator != nil {
+		token, err := m.propagator.Extract(ctx.Req)
+		if err == nil && token != "" {
+			return token, nil
+		}
+		token, err = newToken()
+		if err != nil {
+			return "", err
+		}
+		return token, m.propagator.Inject(token, ctx.Resp)
+	}
+
+	sess, err := m.manager.GetSession(ctx)
+	if err != nil {
+		return "", err
+	}
+	token, err := sess.Get(ctx.Req.Context(), m.sessionKey)
+	if err == nil && token != "" {
+		return token, nil
+	}
+	token, err = newToken()
+	if err != nil {
+		return "", err
+	}
+	return token, sess.Set(ctx.Req.Context(), m.sessionKey, token)
+}
+
+// valid 优先使用请求头，其次是表单字段
+func (m MiddlewareBuilder) valid(ctx *web.Context, token string) bool {
+	submitted := ctx.Req.Header.Get(m.headerName)
+	if submitted == "" {
+		submitted = ctx.Req.FormValue(m.fieldName)
+	}
+	return submitted != "" && subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) == 1
+}
+
+// expose 提供给handler和模板使用
+func (m MiddlewareBuilder) expose(ctx *web.Context, token string) {
+	if ctx.UserValues == nil {
+		ctx.UserValues = make(map[string]any, 8)
+	}
+	ctx.UserValues[tokenCtxKey] = token
+	ctx.UserValues[fieldCtxKey] = m.fieldName
+	field := hiddenField(m.fieldName, token)
+	ctx.Req = ctx.Req.WithContext(web.WithTemplateFuncs(ctx.Req.Context(), template.FuncMap{
+		"csrfToken": func() string { return token },
+		"csrfField": func() template.HTML { return field },
+	}))
+}
+
+// Token 当前请求的csrf token，没有经过csrf middleware时返回空字符串
+func Token(ctx *web.Context) string {
+	token, _ := ctx.UserValues[tokenCtxKey].(string)
+	return token
+}
+
+// TemplateField 包含token的隐藏表单字段，可以放在渲染模板的数据里面
+func TemplateField(ctx *web.Context) template.HTML {
+	name, _ := ctx.UserValues[fieldCtxKey].(string)
+	return hiddenField(name, Token(ctx))
+}
+
+// FuncMap 解析模板的时候注册，模板中使用{{ csrfField }}或者{{ csrfToken }}
+// 这里只是占位，真正的实现由middleware针对每个请求设置
+func FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"csrfToken": func() string { return "" },
+		"csrfField": func() template.HTML { return "" },
+	}
+}
+
+func hiddenField(name string, token string) template.HTML {
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(name) +
+		`" value="` + template.HTMLEscapeString(token) + `">`)
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func newToken() (string, error) {
+	bs := make([]byte, 32)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bs), nil
+}
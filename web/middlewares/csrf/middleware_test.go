@@ -0,0 +1,152 @@
+package csrf
+
+import (
+	"context"
+	"github.com/dongma/imola/web"
+	"github.com/dongma/imola/web/session"
+	"github.com/dongma/imola/web/session/cookie"
+	"github.com/dongma/imola/web/session/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareBuilder_Session(t *testing.T) {
+	store := memory.NewStore(time.Minute)
+	manager := &session.Manager{
+		Store:         store,
+		Propagator:    cookie.NewPropagator("sessid"),
+		SessionCtxKey: "_sess",
+	}
+	_, err := store.Generate(context.Background(), "sess-1")
+	require.NoError(t, err)
+
+	tpl, err := template.New("form").Funcs(FuncMap()).
+		Parse(`<form method="post">{{ csrfField }}</form>`)
+	require.NoError(t, err)
+	server := web.NewHTTPServer(
+		web.ServerWithTemplateEngine(&web.GoTemplateEngine{T: tpl}),
+		web.ServerWithMiddleware(NewMiddlewareBuilder(manager).Build()))
+	server.GET("/form", func(ctx *web.Context) {
+		_ = ctx.Render("form", nil)
+	})
+	server.POST("/form", func(ctx *web.Context) {
+		ctx.RespData = []byte("ok")
+	})
+
+	send := func(req *http.Request) *httptest.ResponseRecorder {
+		req.AddCookie(&http.Cookie{Name: "sessid", Value: "sess-1"})
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	resp := send(httptest.NewRequest(http.MethodGet, "/form", nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+	matches := regexp.MustCompile(`name="csrf_token" value="([^"]+)"`).FindStringSubmatch(resp.Body.String())
+	require.Len(t, matches, 2)
+	token := matches[1]
+
+	testCases := []struct {
+		name     string
+		req      func() *http.Request
+		wantCode int
+	}{
+		{
+			name: "form",
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/form",
+					strings.NewReader(url.Values{"csrf_token": {token}}.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return req
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "header",
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/form", nil)
+				req.Header.Set("X-CSRF-Token", token)
+				return req
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "missing",
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/form", nil)
+			},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name: "wrong",
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/form", nil)
+				req.Header.Set("X-CSRF-Token", token+"x")
+				return req
+			},
+			wantCode: http.StatusForbidden,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.wantCode, send(tc.req()).Code)
+		})
+	}
+
+	// 没有session的请求无法通过校验
+	req := httptest.NewRequest(http.MethodPost, "/form", nil)
+	req.Header.Set("X-CSRF-Token", token)
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+}
+
+func TestMiddlewareBuilder_DoubleSubmit(t *testing.T) {
+	builder := NewDoubleSubmitBuilder("csrf", cookie.WithCookieOption(func(c *http.Cookie) {
+		c.Path = "/"
+	}))
+	server := web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
+	server.GET("/token", func(ctx *web.Context) {
+		ctx.RespData = []byte(Token(ctx))
+	})
+	server.POST("/order", func(ctx *web.Context) {
+		ctx.RespData = []byte("ok")
+	})
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/token", nil))
+	cookies := recorder.Result().Cookies()
+	require.Len(t, cookies, 1)
+	token := cookies[0].Value
+	assert.Equal(t, token, recorder.Body.String())
+
+	req := httptest.NewRequest(http.MethodPost, "/order", nil)
+	req.AddCookie(&http.Cookie{Name: "csrf", Value: token})
+	req.Header.Set("X-CSRF-Token", token)
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	// 只有请求头，没有cookie
+	req = httptest.NewRequest(http.MethodPost, "/order", nil)
+	req.Header.Set("X-CSRF-Token", token)
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+}
+
+func TestTemplateField(t *testing.T) {
+	ctx := &web.Context{UserValues: map[string]any{
+		tokenCtxKey: `a"b`,
+		fieldCtxKey: "_token",
+	}}
+	assert.Equal(t, template.HTML(`<input type="hidden" name="_token" value="a&#34;b">`), TemplateField(ctx))
+}
@@ -1,12 +1,17 @@
 package errhdl
 
 import (
+	"encoding/json"
+	"errors"
 	"github.com/dongma/imola/web"
+	"net/http"
 )
 
 type MiddlewareBuilder struct {
 	// 只能返回固定的值，不能进行动态渲染
 	resp map[int][]byte
+	// 参数绑定、校验失败时，是否返回400以及JSON格式的错误详情
+	respValidation bool
 }
 
 func NewMiddlewareBuilder() *MiddlewareBuilder {
@@ -20,10 +25,19 @@ func (m *MiddlewareBuilder) AddCode(status int, data []byte) *MiddlewareBuilder
 	return m
 }
 
+// RespValidationError ctx.Err是web.ValidationError或者web.BindError时，返回400以及每个字段的错误详情
+func (m *MiddlewareBuilder) RespValidationError() *MiddlewareBuilder {
+	m.respValidation = true
+	return m
+}
+
 func (m MiddlewareBuilder) Build() web.Middleware {
 	return func(next web.HandleFunc) web.HandleFunc {
 		return func(ctx *web.Context) {
 			next(ctx)
+			if m.respValidation && m.handleValidation(ctx) {
+				return
+			}
 			resp, ok := m.resp[ctx.RespStatusCode]
 			if ok {
 				// 篡改结果
@@ -32,3 +46,31 @@ func (m MiddlewareBuilder) Build() web.Middleware {
 		}
 	}
 }
+
+// handleValidation 把参数错误转换为JSON响应，返回是否处理了
+func (m MiddlewareBuilder) handleValidation(ctx *web.Context) bool {
+	if ctx.Err == nil {
+		return false
+	}
+	var validationErr *web.ValidationError
+	var bindErr *web.BindError
+	switch {
+	case errors.As(ctx.Err, &validationErr):
+	case errors.As(ctx.Err, &bindErr):
+		validationErr = &web.ValidationError{
+			Errors: []web.FieldError{
+				{Field: bindErr.Field, Rule: "bind", Message: bindErr.Err.Error()},
+			},
+		}
+	default:
+		return false
+	}
+	data, err := json.Marshal(validationErr)
+	if err != nil {
+		return false
+	}
+	ctx.Resp.Header().Set("Content-Type", "application/json")
+	ctx.RespStatusCode = http.StatusBadRequest
+	ctx.RespData = data
+	return true
+}
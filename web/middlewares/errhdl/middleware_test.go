@@ -2,7 +2,10 @@ package errhdl
 
 import (
 	"github.com/dongma/imola/web"
+	"github.com/stretchr/testify/assert"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -24,3 +27,60 @@ func TestMiddlewareBuilder_Build(t *testing.T) {
 	sever := web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
 	sever.Start(":8081")
 }
+
+func TestMiddlewareBuilder_RespValidationError(t *testing.T) {
+	type signUpReq struct {
+		Name  string `form:"name" validate:"required,max=8"`
+		Email string `form:"email" validate:"omitempty,email"`
+		Age   int    `form:"age" validate:"min=1,max=150"`
+		Role  string `form:"role" validate:"oneof=admin user"`
+	}
+	builder := NewMiddlewareBuilder().RespValidationError().
+		AddCode(http.StatusBadRequest, []byte("请求不对"))
+	server := web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
+	server.POST("/signup", func(ctx *web.Context) {
+		var req signUpReq
+		if err := ctx.BindForm(&req); err != nil {
+			return
+		}
+		ctx.RespStatusCode = http.StatusOK
+	})
+
+	testCases := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "ok",
+			body:     "name=tom&email=tom@example.com&age=18&role=admin",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "validation",
+			body:     "email=tom&age=0&role=guest",
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"errors":[{"field":"Name","rule":"required","message":"不能为空"},` +
+				`{"field":"Email","rule":"email","message":"不是合法的邮箱地址"},` +
+				`{"field":"Age","rule":"min","param":"1","message":"值不能小于1"},` +
+				`{"field":"Role","rule":"oneof","param":"admin user","message":"必须是[admin user]其中之一"}]}`,
+		},
+		{
+			name:     "bind",
+			body:     "name=tom&age=abc&role=user",
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"errors":[{"field":"Age","rule":"bind","message":"strconv.ParseInt: parsing \"abc\": invalid syntax"}]}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/signup", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package jwt
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+// KeySet 校验签名使用的密钥，key是kid
+// 支持的密钥类型：HS256使用[]byte，RS256使用*rsa.PublicKey，ES256使用*ecdsa.PublicKey
+type KeySet struct {
+	mutex sync.RWMutex
+	keys  map[string]any
+	// 没有kid的密钥，只有token的header中没有kid时才会使用
+	anonymous []any
+
+	// 从JWKS文件加载时使用
+	path      string
+	interval  time.Duration
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// NewKeySet 手动管理密钥，一般用于HS256
+func NewKeySet() *KeySet {
+	return &KeySet{keys: make(map[string]any, 4)}
+}
+
+// AddKey kid可以为空，token的header中没有kid时会尝试所有类型匹配的密钥
+func (k *KeySet) AddKey(kid string, key any) error {
+	switch key.(type) {
+	case []byte, *rsa.PublicKey, *ecdsa.PublicKey:
+	default:
+		return fmt.Errorf("jwt: 不支持的密钥类型 %T", key)
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if kid == "" {
+		k.anonymous = append(k.anonymous, key)
+		return nil
+	}
+	k.keys[kid] = key
+	return nil
+}
+
+// LoadJWKSFile 从JWKS文件中加载公钥，interval大于0时，每隔interval检查一次文件是否发生变化
+// 轮换密钥的时候先把新的公钥加入文件，等旧的token过期之后再删除旧的公钥
+func LoadJWKSFile(path string, interval time.Duration) (*KeySet, error) {
+	res := &KeySet{path: path, interval: interval}
+	if err := res.Reload(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Reload 重新读取JWKS文件，失败时继续使用原来的密钥
+func (k *KeySet) Reload() error {
+	if k.path == "" {
+		return errors.New("jwt: KeySet不是从JWKS文件加载的")
+	}
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(k.path)
+	if err != nil {
+		return err
+	}
+	keys, anonymous, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.keys = keys
+	k.anonymous = anonymous
+	k.modTime = info.ModTime()
+	k.lastCheck = time.Now()
+	return nil
+}
+
+// reloadIfModified 文件的修改时间发生变化时重新加载
+func (k *KeySet) reloadIfModified() {
+	if k.path == "" || k.interval <= 0 {
+		return
+	}
+	k.mutex.Lock()
+	if time.Since(k.lastCheck) < k.interval {
+		k.mutex.Unlock()
+		return
+	}
+	k.lastCheck = time.Now()
+	modTime := k.modTime
+	k.mutex.Unlock()
+
+	info, err := os.Stat(k.path)
+	if err != nil || info.ModTime().Equal(modTime) {
+		return
+	}
+	_ = k.Reload()
+}
+
+// candidates 找到可以用来校验的密钥，kid为空时返回所有的密钥，不为空时只使用kid对应的密钥
+func (k *KeySet) candidates(kid string) []any {
+	k.reloadIfModified()
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	if kid != "" {
+		if key, ok := k.keys[kid]; ok {
+			return []any{key}
+		}
+		return nil
+	}
+	res := make([]any, 0, len(k.keys)+len(k.anonymous))
+	for _, key := range k.keys {
+		res = append(res, key)
+	}
+	return append(res, k.anonymous...)
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	// oct
+	K string `json:"k"`
+}
+
+// parseJWKS 有kid的密钥放在map中，kid重复时返回错误，否则使用哪个密钥取决于文件中的顺序
+func parseJWKS(data []byte) (map[string]any, []any, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, nil, fmt.Errorf("jwt: JWKS格式错误: %w", err)
+	}
+	keys := make(map[string]any, len(set.Keys))
+	var anonymous []any
+	for _, key := range set.Keys {
+		// 只用于加密的密钥跳过
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		parsed, err := key.parse()
+		if err != nil {
+			return nil, nil, fmt.Errorf("jwt: 解析密钥 %s 失败: %w", key.Kid, err)
+		}
+		if key.Kid == "" {
+			anonymous = append(anonymous, parsed)
+			continue
+		}
+		if _, ok := keys[key.Kid]; ok {
+			return nil, nil, fmt.Errorf("jwt: 密钥 %s 重复", key.Kid)
+		}
+		keys[key.Kid] = parsed
+	}
+	return keys, anonymous, nil
+}
+
+func (j jwk) parse() (any, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := decodeBigInt(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(j.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA指数过大")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if j.Crv != "P-256" {
+			return nil, fmt.Errorf("不支持的曲线 %s", j.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("P-256的坐标必须是32字节")
+		}
+		// 借助ecdh校验点是否在曲线上
+		point := append(append([]byte{4}, x...), y...)
+		if _, err = ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(j.K)
+	}
+	return nil, fmt.Errorf("不支持的密钥类型 %s", j.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	bs, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(bs), nil
+}
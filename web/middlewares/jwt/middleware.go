@@ -0,0 +1,113 @@
+package jwt
+
+import (
+	"github.com/dongma/imola/web"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ClaimsCtxKey 校验通过之后，Claims保存在ctx.UserValues中的key
+const ClaimsCtxKey = "jwt_claims"
+
+// MiddlewareBuilder 校验Authorization: Bearer <token>，只依赖标准库
+type MiddlewareBuilder struct {
+	validator validator
+	// optional 为true时，没有token的请求直接放过，带了token但是校验失败依旧返回401
+	optional bool
+}
+
+// NewMiddlewareBuilder 默认接受HS256、RS256、ES256，没有exp的token同样认为有效
+func NewMiddlewareBuilder(keys *KeySet) *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		validator: validator{
+			keys: keys,
+			algs: []string{HS256, RS256, ES256},
+			now:  time.Now,
+		},
+	}
+}
+
+// Algorithms 限制接受的签名算法
+func (m *MiddlewareBuilder) Algorithms(algs ...string) *MiddlewareBuilder {
+	m.validator.algs = algs
+	return m
+}
+
+// Issuer 校验iss
+func (m *MiddlewareBuilder) Issuer(iss string) *MiddlewareBuilder {
+	m.validator.issuer = iss
+	return m
+}
+
+// Audience 校验aud中包含aud
+func (m *MiddlewareBuilder) Audience(aud string) *MiddlewareBuilder {
+	m.validator.audience = aud
+	return m
+}
+
+// ClockSkew 校验exp、nbf时允许的时钟误差
+func (m *MiddlewareBuilder) ClockSkew(skew time.Duration) *MiddlewareBuilder {
+	m.validator.clockSkew = skew
+	return m
+}
+
+// Optional 没有token的请求也可以通过，是否需要登录交给后面的handler或者session的Guard判断
+func (m *MiddlewareBuilder) Optional() *MiddlewareBuilder {
+	m.optional = true
+	return m
+}
+
+func (m MiddlewareBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			token, ok := bearerToken(ctx.Req)
+			if !ok {
+				if m.optional {
+					next(ctx)
+					return
+				}
+				unauthorized(ctx, `Bearer`)
+				return
+			}
+			claims, err := m.validator.parse(token)
+			if err != nil {
+				ctx.Err = err
+				unauthorized(ctx, `Bearer error="invalid_token"`)
+				return
+			}
+			if ctx.UserValues == nil {
+				ctx.UserValues = make(map[string]any, 8)
+			}
+			ctx.UserValues[ClaimsCtxKey] = claims
+			next(ctx)
+		}
+	}
+}
+
+// ClaimsFrom 拿到jwt middleware校验通过的Claims
+func ClaimsFrom(ctx *web.Context) (Claims, bool) {
+	claims, ok := ctx.UserValues[ClaimsCtxKey].(Claims)
+	return claims, ok
+}
+
+// Authenticated 可以作为session.GuardBuilder.AllowIf的参数，带了有效token的请求不需要session
+func Authenticated(ctx *web.Context) bool {
+	_, ok := ClaimsFrom(ctx)
+	return ok
+}
+
+func bearerToken(req *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(req.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+func unauthorized(ctx *web.Context, challenge string) {
+	ctx.Resp.Header().Set("WWW-Authenticate", challenge)
+	ctx.RespStatusCode = http.StatusUnauthorized
+	ctx.RespData = []byte("unauthorized")
+}
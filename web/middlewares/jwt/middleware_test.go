@@ -0,0 +1,291 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/dongma/imola/web"
+	"github.com/dongma/imola/web/session"
+	"github.com/dongma/imola/web/session/cookie"
+	"github.com/dongma/imola/web/session/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// sign 测试用的签名，key是[]byte、*rsa.PrivateKey或者*ecdsa.PrivateKey
+func sign(t *testing.T, alg string, kid string, key any, claims Claims) string {
+	hdr, err := json.Marshal(header{Alg: alg, Kid: kid, Typ: "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	input := base64.RawURLEncoding.EncodeToString(hdr) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(input))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, er := ecdsa.Sign(rand.Reader, k, digest[:])
+		require.NoError(t, er)
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func b64(bs []byte) string {
+	return base64.RawURLEncoding.EncodeToString(bs)
+}
+
+func writeJWKS(t *testing.T, path string, keys ...map[string]string) {
+	data, err := json.Marshal(map[string]any{"keys": keys})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0600))
+}
+
+func rsaJWK(kid string, key *rsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA", "kid": kid, "use": "sig",
+		"n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) map[string]string {
+	x, y := make([]byte, 32), make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+	return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(x), "y": b64(y)}
+}
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	hsKey := []byte("0123456789abcdef0123456789abcdef")
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, rsaJWK("rsa-1", rsaKey), ecJWK("ec-1", ecKey),
+		map[string]string{"kty": "oct", "kid": "hs-1", "k": b64(hsKey)})
+	keys, err := LoadJWKSFile(path, 0)
+	require.NoError(t, err)
+
+	now := time.Now()
+	builder := NewMiddlewareBuilder(keys).Issuer("imola").Audience("api").ClockSkew(time.Minute)
+	server := web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
+	server.GET("/me", func(ctx *web.Context) {
+		claims, ok := ClaimsFrom(ctx)
+		require.True(t, ok)
+		ctx.RespData = []byte(claims.Subject())
+	})
+
+	valid := func() Claims {
+		return Claims{"sub": "tom", "iss": "imola", "aud": []string{"web", "api"}, "exp": now.Add(time.Hour).Unix()}
+	}
+	with := func(key string, val any) Claims {
+		c := valid()
+		c[key] = val
+		return c
+	}
+	testCases := []struct {
+		name      string
+		token     string
+		wantCode  int
+		wantBody  string
+		wantError error
+	}{
+		{name: "HS256", token: sign(t, HS256, "hs-1", hsKey, valid()), wantCode: http.StatusOK, wantBody: "tom"},
+		{name: "RS256", token: sign(t, RS256, "rsa-1", rsaKey, valid()), wantCode: http.StatusOK, wantBody: "tom"},
+		{name: "ES256", token: sign(t, ES256, "ec-1", ecKey, valid()), wantCode: http.StatusOK, wantBody: "tom"},
+		{name: "without kid", token: sign(t, ES256, "", ecKey, valid()), wantCode: http.StatusOK, wantBody: "tom"},
+		{
+			name:     "expired within skew",
+			token:    sign(t, HS256, "hs-1", hsKey, with("exp", now.Add(-30*time.Second).Unix())),
+			wantCode: http.StatusOK, wantBody: "tom",
+		},
+		{
+			name:      "expired",
+			token:     sign(t, HS256, "hs-1", hsKey, with("exp", now.Add(-2*time.Minute).Unix())),
+			wantCode:  http.StatusUnauthorized,
+			wantError: errExpired,
+		},
+		{
+			name:      "not valid yet",
+			token:     sign(t, HS256, "hs-1", hsKey, with("nbf", now.Add(2*time.Minute).Unix())),
+			wantCode:  http.StatusUnauthorized,
+			wantError: errNotValidYet,
+		},
+		{
+			// exp存在但不是数字，不能当作没有过期时间
+			name:      "exp not numeric",
+			token:     sign(t, HS256, "hs-1", hsKey, with("exp", "tomorrow")),
+			wantCode:  http.StatusUnauthorized,
+			wantError: errMalformed,
+		},
+		{
+			name:      "nbf not numeric",
+			token:     sign(t, HS256, "hs-1", hsKey, with("nbf", true)),
+			wantCode:  http.StatusUnauthorized,
+			wantError: errMalformed,
+		},
+		{
+			name:      "issuer",
+			token:     sign(t, HS256, "hs-1", hsKey, with("iss", "other")),
+			wantCode:  http.StatusUnauthorized,
+			wantError: errInvalidIssuer,
+		},
+		{
+			name:      "audience",
+			token:     sign(t, HS256, "hs-1", hsKey, with("aud", "web")),
+			wantCode:  http.StatusUnauthorized,
+			wantError: errInvalidAudience,
+		},
+		{
+			name:      "wrong key",
+			token:     sign(t, HS256, "hs-1", []byte("another key"), valid()),
+			wantCode:  http.StatusUnauthorized,
+			wantError: errInvalidSignature,
+		},
+		{
+			// 使用RSA的kid，但是声明为HS256
+			name:      "algorithm confusion",
+			token:     sign(t, HS256, "rsa-1", hsKey, valid()),
+			wantCode:  http.StatusUnauthorized,
+			wantError: errInvalidSignature,
+		},
+		{
+			name:      "none",
+			token:     sign(t, "none", "", nil, valid()),
+			wantCode:  http.StatusUnauthorized,
+			wantError: errUnsupportedAlg,
+		},
+		{
+			name:      "malformed",
+			token:     "abc.def",
+			wantCode:  http.StatusUnauthorized,
+			wantError: errMalformed,
+		},
+		{name: "missing", wantCode: http.StatusUnauthorized},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/me", nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			if tc.wantCode == http.StatusOK {
+				assert.Equal(t, tc.wantBody, recorder.Body.String())
+				return
+			}
+			assert.Contains(t, recorder.Header().Get("WWW-Authenticate"), "Bearer")
+			if tc.wantError != nil {
+				_, err := builder.validator.parse(tc.token)
+				assert.Equal(t, tc.wantError, err)
+			}
+		})
+	}
+}
+
+func TestKeySet_Reload(t *testing.T) {
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, ecJWK("old", oldKey))
+	keys, err := LoadJWKSFile(path, time.Nanosecond)
+	require.NoError(t, err)
+	v := validator{keys: keys, algs: []string{ES256}, now: time.Now}
+
+	newToken := sign(t, ES256, "new", newKey, Claims{"sub": "tom"})
+	_, err = v.parse(newToken)
+	assert.Equal(t, errInvalidSignature, err)
+
+	// 轮换：加入新的公钥
+	writeJWKS(t, path, ecJWK("old", oldKey), ecJWK("new", newKey))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, later, later))
+	claims, err := v.parse(newToken)
+	require.NoError(t, err)
+	assert.Equal(t, "tom", claims.Subject())
+
+	// 文件损坏的时候继续使用原来的公钥
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0600))
+	later = later.Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, later, later))
+	_, err = v.parse(newToken)
+	require.NoError(t, err)
+}
+
+func TestLoadJWKSFile_Kid(t *testing.T) {
+	oneKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	anotherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+
+	// 两个密钥使用同一个kid
+	writeJWKS(t, path, ecJWK("same", oneKey), ecJWK("same", anotherKey))
+	_, err = LoadJWKSFile(path, 0)
+	assert.Error(t, err)
+
+	// 没有kid的密钥只用于没有kid的token
+	writeJWKS(t, path, ecJWK("", anotherKey))
+	keys, err := LoadJWKSFile(path, 0)
+	require.NoError(t, err)
+	v := validator{keys: keys, algs: []string{ES256}, now: time.Now}
+	_, err = v.parse(sign(t, ES256, "", anotherKey, Claims{"sub": "tom"}))
+	require.NoError(t, err)
+	_, err = v.parse(sign(t, ES256, "unknown", anotherKey, Claims{"sub": "tom"}))
+	assert.Equal(t, errInvalidSignature, err)
+}
+
+func TestMiddlewareBuilder_Guard(t *testing.T) {
+	keys := NewKeySet()
+	hsKey := []byte("0123456789abcdef0123456789abcdef")
+	require.NoError(t, keys.AddKey("", hsKey))
+	manager := &session.Manager{
+		Store:         memory.NewStore(time.Minute),
+		Propagator:    cookie.NewPropagator("sessid"),
+		SessionCtxKey: "_sess",
+	}
+	server := web.NewHTTPServer(web.ServerWithMiddleware(NewMiddlewareBuilder(keys).Optional().Build()))
+	api := server.Group("/api", session.NewGuardBuilder(manager).AllowIf(Authenticated).Build())
+	api.GET("/me", func(ctx *web.Context) {
+		claims, _ := ClaimsFrom(ctx)
+		ctx.RespData = []byte(claims.Subject())
+	})
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/me", nil))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/me", nil)
+	req.Header.Set("Authorization", "Bearer "+sign(t, HS256, "", hsKey, Claims{"sub": "tom"}))
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "tom", recorder.Body.String())
+}
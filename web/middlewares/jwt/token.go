@@ -0,0 +1,214 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// 支持的签名算法
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+var (
+	errMalformed        = errors.New("jwt: token格式错误")
+	errUnsupportedAlg   = errors.New("jwt: 不支持的签名算法")
+	errInvalidSignature = errors.New("jwt: 签名错误")
+	errExpired          = errors.New("jwt: token已过期")
+	errNotValidYet      = errors.New("jwt: token还没有生效")
+	errInvalidIssuer    = errors.New("jwt: iss不匹配")
+	errInvalidAudience  = errors.New("jwt: aud不匹配")
+)
+
+// Claims token中的所有声明，数字统一解析为float64
+type Claims map[string]any
+
+func (c Claims) Subject() string {
+	return c.String("sub")
+}
+
+func (c Claims) Issuer() string {
+	return c.String("iss")
+}
+
+// Audience aud可以是字符串也可以是字符串数组
+func (c Claims) Audience() []string {
+	switch aud := c["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []any:
+		res := make([]string, 0, len(aud))
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+	return nil
+}
+
+// String 字符串类型的声明，不存在或者不是字符串时返回空字符串
+func (c Claims) String(key string) string {
+	s, _ := c[key].(string)
+	return s
+}
+
+// Time exp、nbf、iat这种NumericDate类型的声明，不存在或者格式不对时返回false
+func (c Claims) Time(key string) (time.Time, bool) {
+	t, ok, err := c.numericDate(key)
+	return t, ok && err == nil
+}
+
+// numericDate 区分声明不存在和声明存在但不是数字两种情况，后者返回errMalformed
+func (c Claims) numericDate(key string) (time.Time, bool, error) {
+	val, ok := c[key]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	switch v := val.(type) {
+	case float64:
+		return time.Unix(int64(v), 0), true, nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return time.Unix(i, 0), true, nil
+		}
+		if f, err := v.Float64(); err == nil {
+			return time.Unix(int64(f), 0), true, nil
+		}
+	}
+	return time.Time{}, true, errMalformed
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// validator 校验签名和声明
+type validator struct {
+	keys      *KeySet
+	algs      []string
+	issuer    string
+	audience  string
+	clockSkew time.Duration
+	now       func() time.Time
+}
+
+func (v validator) parse(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errMalformed
+	}
+	var hdr header
+	if err := decodeSegment(parts[0], &hdr); err != nil {
+		return nil, errMalformed
+	}
+	if !v.allowAlg(hdr.Alg) {
+		return nil, errUnsupportedAlg
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errMalformed
+	}
+	if !v.verify(hdr, parts[0]+"."+parts[1], sig) {
+		return nil, errInvalidSignature
+	}
+	var claims Claims
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, errMalformed
+	}
+	return claims, v.validateClaims(claims)
+}
+
+func (v validator) allowAlg(alg string) bool {
+	for _, a := range v.algs {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// verify 密钥的类型必须和alg匹配，避免使用RSA公钥作为HMAC密钥这种算法混淆攻击
+func (v validator) verify(hdr header, signingInput string, sig []byte) bool {
+	digest := sha256.Sum256([]byte(signingInput))
+	for _, key := range v.keys.candidates(hdr.Kid) {
+		switch k := key.(type) {
+		case []byte:
+			if hdr.Alg != HS256 {
+				continue
+			}
+			mac := hmac.New(sha256.New, k)
+			mac.Write([]byte(signingInput))
+			if hmac.Equal(sig, mac.Sum(nil)) {
+				return true
+			}
+		case *rsa.PublicKey:
+			if hdr.Alg == RS256 && rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil {
+				return true
+			}
+		case *ecdsa.PublicKey:
+			// ES256的签名是r和s各32字节拼接在一起，不是ASN.1格式
+			if hdr.Alg != ES256 || k.Curve != elliptic.P256() || len(sig) != 64 {
+				continue
+			}
+			r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+			if ecdsa.Verify(k, digest[:], r, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (v validator) validateClaims(claims Claims) error {
+	now := v.now()
+	exp, ok, err := claims.numericDate("exp")
+	if err != nil {
+		return err
+	}
+	if ok && !now.Before(exp.Add(v.clockSkew)) {
+		return errExpired
+	}
+	nbf, ok, err := claims.numericDate("nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(v.clockSkew).Before(nbf) {
+		return errNotValidYet
+	}
+	if v.issuer != "" && claims.Issuer() != v.issuer {
+		return errInvalidIssuer
+	}
+	if v.audience != "" {
+		for _, aud := range claims.Audience() {
+			if aud == v.audience {
+				return nil
+			}
+		}
+		return errInvalidAudience
+	}
+	return nil
+}
+
+func decodeSegment(seg string, val any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, val)
+}
@@ -0,0 +1,195 @@
+package ratelimit
+
+import (
+	"github.com/dongma/imola/micro/rate_limit"
+	"github.com/dongma/imola/web"
+	"github.com/dongma/imola/web/session"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyFunc 计算限流的key，每个key使用独立的Limiter
+// 空字符串同样是一个合法的key，所有拿不到key的请求共享同一个Limiter
+type KeyFunc func(ctx *web.Context) string
+
+// MiddlewareBuilder 基于micro/rate_limit中的限流算法对http请求限流
+// 被限流时返回429，同时设置Retry-After和X-RateLimit-*响应头
+type MiddlewareBuilder struct {
+	newLimiter func() rate_limit.Limiter
+	keyFunc    KeyFunc
+	// 超过idleTimeout没有请求的key会被清理掉
+	idleTimeout time.Duration
+}
+
+// NewMiddlewareBuilder newLimiter为每个key创建一个Limiter，默认所有请求使用同一个key
+func NewMiddlewareBuilder(newLimiter func() rate_limit.Limiter) *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		newLimiter: newLimiter,
+		keyFunc: func(ctx *web.Context) string {
+			return ""
+		},
+		idleTimeout: 10 * time.Minute,
+	}
+}
+
+// KeyFunc 设置限流的维度，例如KeyByIP、KeyByRoute
+func (m *MiddlewareBuilder) KeyFunc(fn KeyFunc) *MiddlewareBuilder {
+	m.keyFunc = fn
+	return m
+}
+
+// IdleTimeout 设置key的空闲时间，空闲的Limiter实现了io.Closer时会被关闭
+func (m *MiddlewareBuilder) IdleTimeout(timeout time.Duration) *MiddlewareBuilder {
+	m.idleTimeout = timeout
+	return m
+}
+
+func (m MiddlewareBuilder) Build() web.Middleware {
+	limiters := &limiterCache{
+		newLimiter:  m.newLimiter,
+		idleTimeout: m.idleTimeout,
+		entries:     make(map[string]*limiterEntry, 16),
+		lastClean:   time.Now(),
+	}
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			limiter := limiters.get(m.keyFunc(ctx))
+			res, err := limiter.Allow(ctx.Req.Context())
+			header := ctx.Resp.Header()
+			if res.Limit > 0 {
+				header.Set("X-RateLimit-Limit", strconv.FormatInt(res.Limit, 10))
+				header.Set("X-RateLimit-Remaining", strconv.FormatInt(res.Remaining, 10))
+				if !res.Reset.IsZero() {
+					header.Set("X-RateLimit-Reset", strconv.FormatInt(res.Reset.Unix(), 10))
+				}
+			}
+			// Limiter出错的时候同样拒绝请求，避免服务失去保护
+			if err != nil || !res.Allowed {
+				if res.RetryAfter > 0 {
+					header.Set("Retry-After", strconv.Itoa(int(math.Ceil(res.RetryAfter.Seconds()))))
+				}
+				ctx.RespStatusCode = http.StatusTooManyRequests
+				ctx.RespData = []byte("too many requests")
+				return
+			}
+			next(ctx)
+		}
+	}
+}
+
+// KeyByIP 按照客户端IP限流，headers是信任的代理头，例如X-Forwarded-For、X-Real-IP
+// 只有在服务部署在可信的反向代理后面时才应该设置headers，否则客户端可以伪造IP
+// 相当于KeyByTrustedIP(1, headers...)，也就是只有一层代理
+func KeyByIP(headers ...string) KeyFunc {
+	return KeyByTrustedIP(1, headers...)
+}
+
+// KeyByTrustedIP 按照客户端IP限流，hops是可信代理的层数
+// X-Forwarded-For最左边的值是客户端自己带上来的，可以随意伪造，只有代理追加在右边的值才可信，
+// 所以使用从右往左数第hops个值，值的数量不够时使用RemoteAddr
+func KeyByTrustedIP(hops int, headers ...string) KeyFunc {
+	if hops < 1 {
+		panic("ratelimit: 可信代理的层数必须大于0")
+	}
+	return func(ctx *web.Context) string {
+		for _, h := range headers {
+			vals := ctx.Req.Header.Values(h)
+			if len(vals) == 0 {
+				continue
+			}
+			// 多个同名请求头等价于用逗号连接起来
+			ips := strings.Split(strings.Join(vals, ","), ",")
+			if len(ips) < hops {
+				break
+			}
+			if ip := strings.TrimSpace(ips[len(ips)-hops]); ip != "" {
+				return ip
+			}
+			break
+		}
+		host, _, err := net.SplitHostPort(ctx.Req.RemoteAddr)
+		if err != nil {
+			return ctx.Req.RemoteAddr
+		}
+		return host
+	}
+}
+
+// KeyByRoute 按照命中的路由限流，MatchedRoute在路由匹配之后才有值
+// 所以需要通过HTTPServer.Use注册到路由上，而不是作为全局的middleware
+func KeyByRoute() KeyFunc {
+	return func(ctx *web.Context) string {
+		return ctx.Req.Method + " " + ctx.MatchedRoute
+	}
+}
+
+// KeyByHeader 按照请求头限流，例如按照X-API-Key限制每个调用方
+func KeyByHeader(name string) KeyFunc {
+	return func(ctx *web.Context) string {
+		return ctx.Req.Header.Get(name)
+	}
+}
+
+// KeyBySession 按照session中保存的用户标识限流，没有登录的请求共享同一个Limiter
+func KeyBySession(m *session.Manager, key string) KeyFunc {
+	return func(ctx *web.Context) string {
+		sess, err := m.GetSession(ctx)
+		if err != nil {
+			return ""
+		}
+		val, err := sess.Get(ctx.Req.Context(), key)
+		if err != nil {
+			return ""
+		}
+		return val
+	}
+}
+
+type limiterEntry struct {
+	limiter  rate_limit.Limiter
+	lastSeen time.Time
+}
+
+type limiterCache struct {
+	newLimiter  func() rate_limit.Limiter
+	idleTimeout time.Duration
+	mutex       sync.Mutex
+	entries     map[string]*limiterEntry
+	lastClean   time.Time
+}
+
+func (c *limiterCache) get(key string) rate_limit.Limiter {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	if c.idleTimeout > 0 && now.Sub(c.lastClean) > c.idleTimeout {
+		c.clean(now)
+	}
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &limiterEntry{limiter: c.newLimiter()}
+		c.entries[key] = entry
+	}
+	entry.lastSeen = now
+	return entry.limiter
+}
+
+// clean 清理空闲的key，避免key的数量无限增长
+func (c *limiterCache) clean(now time.Time) {
+	c.lastClean = now
+	for key, entry := range c.entries {
+		if now.Sub(entry.lastSeen) <= c.idleTimeout {
+			continue
+		}
+		delete(c.entries, key)
+		if closer, ok := entry.limiter.(io.Closer); ok {
+			_ = closer.Close()
+		}
+	}
+}
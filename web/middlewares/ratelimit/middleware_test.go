@@ -0,0 +1,154 @@
+package ratelimit
+
+import (
+	"github.com/dongma/imola/micro/rate_limit"
+	"github.com/dongma/imola/web"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	builder := NewMiddlewareBuilder(func() rate_limit.Limiter {
+		return rate_limit.NewFixWindowLimiter(time.Minute, 2)
+	}).KeyFunc(KeyByIP("X-Forwarded-For"))
+	server := web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
+	server.GET("/user", func(ctx *web.Context) {
+		_ = ctx.RespJSONOK("ok")
+	})
+
+	send := func(remoteAddr string, forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/user", nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	resp := send("10.0.0.1:1234", "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "2", resp.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", resp.Header().Get("X-RateLimit-Remaining"))
+	assert.NotEmpty(t, resp.Header().Get("X-RateLimit-Reset"))
+
+	resp = send("10.0.0.1:5678", "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "0", resp.Header().Get("X-RateLimit-Remaining"))
+
+	resp = send("10.0.0.1:1234", "")
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.Equal(t, "0", resp.Header().Get("X-RateLimit-Remaining"))
+	assert.NotEmpty(t, resp.Header().Get("Retry-After"))
+
+	// 其它IP不受影响，代理追加在最右边的才是客户端的IP
+	resp = send("10.0.0.1:1234", "10.0.0.1, 192.168.1.1")
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
+
+func TestKeyByTrustedIP(t *testing.T) {
+	testCases := []struct {
+		name         string
+		hops         int
+		forwardedFor []string
+		want         string
+	}{
+		{
+			// 客户端伪造了最左边的值，代理追加的是真实的地址
+			name:         "spoofed",
+			hops:         1,
+			forwardedFor: []string{"1.2.3.4, 10.0.0.9"},
+			want:         "10.0.0.9",
+		},
+		{
+			name:         "two hops",
+			hops:         2,
+			forwardedFor: []string{"1.2.3.4, 8.8.8.8, 10.0.0.9"},
+			want:         "8.8.8.8",
+		},
+		{
+			name:         "multiple headers",
+			hops:         2,
+			forwardedFor: []string{"1.2.3.4, 8.8.8.8", "10.0.0.9"},
+			want:         "8.8.8.8",
+		},
+		{
+			name:         "not enough hops",
+			hops:         2,
+			forwardedFor: []string{"8.8.8.8"},
+			want:         "192.168.1.1",
+		},
+		{
+			name: "no header",
+			hops: 1,
+			want: "192.168.1.1",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/user", nil)
+			req.RemoteAddr = "192.168.1.1:1234"
+			for _, val := range tc.forwardedFor {
+				req.Header.Add("X-Forwarded-For", val)
+			}
+			key := KeyByTrustedIP(tc.hops, "X-Forwarded-For")(&web.Context{Req: req})
+			assert.Equal(t, tc.want, key)
+		})
+	}
+}
+
+func TestMiddlewareBuilder_KeyByRoute(t *testing.T) {
+	builder := NewMiddlewareBuilder(func() rate_limit.Limiter {
+		return rate_limit.NewSlideWindowLimiter(time.Minute, 1)
+	}).KeyFunc(KeyByRoute())
+	server := web.NewHTTPServer()
+	server.GET("/user/:id", func(ctx *web.Context) {
+		_ = ctx.RespJSONOK("ok")
+	})
+	server.GET("/order/:id", func(ctx *web.Context) {
+		_ = ctx.RespJSONOK("ok")
+	})
+	server.Use(http.MethodGet, "/*", builder.Build())
+
+	testCases := []struct {
+		path     string
+		wantCode int
+	}{
+		{path: "/user/1", wantCode: http.StatusOK},
+		// 同一个路由，参数不同也会被限流
+		{path: "/user/2", wantCode: http.StatusTooManyRequests},
+		{path: "/order/1", wantCode: http.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			assert.Equal(t, tc.wantCode, recorder.Code)
+		})
+	}
+}
+
+func TestLimiterCache_clean(t *testing.T) {
+	cnt := 0
+	cache := &limiterCache{
+		newLimiter: func() rate_limit.Limiter {
+			cnt++
+			return rate_limit.NewFixWindowLimiter(time.Minute, 1)
+		},
+		idleTimeout: time.Minute,
+		entries:     map[string]*limiterEntry{},
+		lastClean:   time.Now(),
+	}
+	first := cache.get("a")
+	assert.Same(t, first, cache.get("a"))
+	assert.Equal(t, 1, cnt)
+
+	cache.entries["a"].lastSeen = time.Now().Add(-2 * time.Minute)
+	cache.lastClean = time.Now().Add(-2 * time.Minute)
+	assert.NotSame(t, first, cache.get("a"))
+	assert.Equal(t, 2, cnt)
+}
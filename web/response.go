@@ -0,0 +1,312 @@
+package web
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	mimeJSON     = "application/json; charset=utf-8"
+	mimeXML      = "application/xml; charset=utf-8"
+	mimeHTML     = "text/html; charset=utf-8"
+	mimeText     = "text/plain; charset=utf-8"
+	mimeProtobuf = "application/x-protobuf"
+	mimeProblem  = "application/problem+json"
+)
+
+// ErrNotAcceptable Respond找不到客户端能够接受的格式
+var ErrNotAcceptable = errors.New("web: 没有客户端可以接受的响应格式")
+
+// setContentType 用户已经设置了Content-Type的时候不覆盖，例如application/vnd.api+json
+func (c *Context) setContentType(contentType string) {
+	header := c.Resp.Header()
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", contentType)
+	}
+}
+
+// RespXML 以XML格式响应
+func (c *Context) RespXML(status int, val any) error {
+	data, err := xml.Marshal(val)
+	if err != nil {
+		return err
+	}
+	c.setContentType(mimeXML)
+	c.RespData = data
+	c.RespStatusCode = status
+	return nil
+}
+
+// RespString 以纯文本响应
+func (c *Context) RespString(status int, val string) error {
+	c.setContentType(mimeText)
+	c.RespData = []byte(val)
+	c.RespStatusCode = status
+	return nil
+}
+
+// RespProtobuf 以protobuf格式响应
+func (c *Context) RespProtobuf(status int, val proto.Message) error {
+	data, err := proto.Marshal(val)
+	if err != nil {
+		return err
+	}
+	c.setContentType(mimeProtobuf)
+	c.RespData = data
+	c.RespStatusCode = status
+	return nil
+}
+
+// Redirect 重定向，status必须是3xx，例如http.StatusFound、http.StatusSeeOther
+func (c *Context) Redirect(status int, location string) error {
+	if status < http.StatusMultipleChoices || status > http.StatusPermanentRedirect {
+		return fmt.Errorf("web: 重定向的响应码必须是3xx, 实际为 %d", status)
+	}
+	c.Resp.Header().Set("Location", location)
+	c.RespData = nil
+	c.RespStatusCode = status
+	return nil
+}
+
+// RespFile 把文件作为响应，支持Range、If-None-Match、If-Modified-Since等条件请求
+// 文件直接写到Resp里面，不经过RespData，middleware只能拿到响应码和RespSize
+func (c *Context) RespFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			c.RespStatusCode = http.StatusNotFound
+		} else {
+			c.RespStatusCode = http.StatusInternalServerError
+		}
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		c.RespStatusCode = http.StatusInternalServerError
+		return err
+	}
+	if info.IsDir() {
+		c.RespStatusCode = http.StatusNotFound
+		return fmt.Errorf("web: %s 是目录", path)
+	}
+	etag, err := fileETag(info, file)
+	if err != nil {
+		c.RespStatusCode = http.StatusInternalServerError
+		return err
+	}
+	c.Resp.Header().Set("ETag", etag)
+	c.serveContent(filepath.Base(path), info.ModTime(), file)
+	return nil
+}
+
+// serveContent 交给http.ServeContent处理条件请求和Range请求，响应头需要在调用之前设置好
+// 没有设置Content-Type的时候ServeContent会根据文件名推断
+func (c *Context) serveContent(name string, modTime time.Time, content io.ReadSeeker) {
+	c.streaming = true
+	http.ServeContent(&recordWriter{ResponseWriter: c.Resp, ctx: c}, c.Req, name, modTime, content)
+}
+
+// recordWriter 直接写Resp的时候记录响应码和写出去的字节数
+type recordWriter struct {
+	http.ResponseWriter
+	ctx *Context
+}
+
+func (r *recordWriter) WriteHeader(status int) {
+	r.ctx.RespStatusCode = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recordWriter) Write(data []byte) (int, error) {
+	if r.ctx.RespStatusCode == 0 {
+		r.ctx.RespStatusCode = http.StatusOK
+	}
+	return r.ctx.streamWrite(data)
+}
+
+// Problem RFC 7807 定义的错误响应，Extensions中的字段会平铺到JSON里面
+type Problem struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	Extensions map[string]any
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	res := make(map[string]any, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		res[k] = v
+	}
+	typ := p.Type
+	if typ == "" {
+		typ = "about:blank"
+	}
+	res["type"] = typ
+	if p.Title != "" {
+		res["title"] = p.Title
+	} else if p.Status != 0 {
+		res["title"] = http.StatusText(p.Status)
+	}
+	if p.Status != 0 {
+		res["status"] = p.Status
+	}
+	if p.Detail != "" {
+		res["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		res["instance"] = p.Instance
+	}
+	return json.Marshal(res)
+}
+
+// RespProblem 以application/problem+json格式响应错误，Status为0时使用500
+func (c *Context) RespProblem(problem *Problem) error {
+	if problem.Status == 0 {
+		problem.Status = http.StatusInternalServerError
+	}
+	data, err := json.Marshal(problem)
+	if err != nil {
+		return err
+	}
+	c.setContentType(mimeProblem)
+	c.RespData = data
+	c.RespStatusCode = problem.Status
+	return nil
+}
+
+// View Respond协商出HTML的时候使用的模板，其余格式只序列化Data
+type View struct {
+	Template string
+	Data     any
+}
+
+// Respond 根据Accept请求头选择响应格式
+// JSON、XML总是可以；val是View并且设置了模板引擎时可以是HTML；val实现了proto.Message时可以是protobuf
+// 客户端没有指定Accept时使用JSON，没有可以接受的格式时响应406并返回ErrNotAcceptable
+func (c *Context) Respond(status int, val any) error {
+	view, isView := val.(View)
+	if p, ok := val.(*View); ok && p != nil {
+		view, isView = *p, true
+	}
+	data := val
+	if isView {
+		data = view.Data
+	}
+
+	offers := make([]string, 0, 4)
+	if isView && c.tplEngine != nil {
+		offers = append(offers, "text/html")
+	}
+	offers = append(offers, "application/json", "application/xml", "text/xml")
+	msg, isProto := data.(proto.Message)
+	if isProto {
+		offers = append(offers, "application/x-protobuf", "application/protobuf")
+	}
+
+	switch negotiate(c.Req.Header.Get("Accept"), offers) {
+	case "text/html":
+		err := c.Render(view.Template, data)
+		if err != nil {
+			return err
+		}
+		c.RespStatusCode = status
+		return nil
+	case "application/json":
+		return c.RespJSON(status, data)
+	case "application/xml", "text/xml":
+		return c.RespXML(status, data)
+	case "application/x-protobuf", "application/protobuf":
+		return c.RespProtobuf(status, msg)
+	}
+	c.RespData = nil
+	c.RespStatusCode = http.StatusNotAcceptable
+	return ErrNotAcceptable
+}
+
+type acceptRange struct {
+	typ     string
+	subtype string
+	q       float64
+}
+
+// negotiate 按照RFC 9110选出客户端最想要的offer，q相同的时候按照offers的顺序
+// accept为空等价于*/*
+func negotiate(accept string, offers []string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+	ranges := parseAccept(accept)
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		q := acceptQuality(ranges, offer)
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+func parseAccept(accept string) []acceptRange {
+	res := make([]acceptRange, 0, 4)
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		typ, subtype, ok := strings.Cut(mediaType, "/")
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		res = append(res, acceptRange{typ: typ, subtype: subtype, q: q})
+	}
+	// 越具体的越靠前，匹配的时候用第一个匹配上的
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].specificity() > res[j].specificity()
+	})
+	return res
+}
+
+func (a acceptRange) specificity() int {
+	switch {
+	case a.typ == "*":
+		return 0
+	case a.subtype == "*":
+		return 1
+	default:
+		return 2
+	}
+}
+
+func acceptQuality(ranges []acceptRange, offer string) float64 {
+	typ, subtype, _ := strings.Cut(offer, "/")
+	for _, r := range ranges {
+		if (r.typ == "*" || r.typ == typ) && (r.subtype == "*" || r.subtype == subtype) {
+			return r.q
+		}
+	}
+	return 0
+}
@@ -0,0 +1,219 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type respUser struct {
+	Name string `json:"name" xml:"name"`
+}
+
+type fakeTemplateEngine struct{}
+
+func (f fakeTemplateEngine) Render(ctx context.Context, tplName string, data any) ([]byte, error) {
+	return []byte("<p>" + tplName + "</p>"), nil
+}
+
+func TestContext_Respond(t *testing.T) {
+	testCases := []struct {
+		name     string
+		accept   string
+		val      any
+		engine   TemplateEngine
+		wantCode int
+		wantType string
+		wantData string
+		wantErr  error
+	}{
+		{
+			name:     "no accept",
+			val:      respUser{Name: "Tom"},
+			wantCode: http.StatusCreated,
+			wantType: "application/json; charset=utf-8",
+			wantData: `{"name":"Tom"}`,
+		},
+		{
+			name:     "xml",
+			accept:   "application/json;q=0.5, application/xml",
+			val:      respUser{Name: "Tom"},
+			wantCode: http.StatusCreated,
+			wantType: "application/xml; charset=utf-8",
+			wantData: `<respUser><name>Tom</name></respUser>`,
+		},
+		{
+			name:     "wildcard prefers server order",
+			accept:   "text/*;q=0.3, */*;q=0.3",
+			val:      respUser{Name: "Tom"},
+			wantCode: http.StatusCreated,
+			wantType: "application/json; charset=utf-8",
+			wantData: `{"name":"Tom"}`,
+		},
+		{
+			name:     "specific range wins over wildcard",
+			accept:   "application/*;q=0.9, application/json;q=0",
+			val:      respUser{Name: "Tom"},
+			wantCode: http.StatusCreated,
+			wantType: "application/xml; charset=utf-8",
+			wantData: `<respUser><name>Tom</name></respUser>`,
+		},
+		{
+			name:     "html",
+			accept:   "text/html,application/xhtml+xml,*/*;q=0.8",
+			val:      View{Template: "user.html", Data: respUser{Name: "Tom"}},
+			engine:   fakeTemplateEngine{},
+			wantCode: http.StatusCreated,
+			wantType: "text/html; charset=utf-8",
+			wantData: `<p>user.html</p>`,
+		},
+		{
+			name:     "view as json",
+			accept:   "application/json",
+			val:      &View{Template: "user.html", Data: respUser{Name: "Tom"}},
+			engine:   fakeTemplateEngine{},
+			wantCode: http.StatusCreated,
+			wantType: "application/json; charset=utf-8",
+			wantData: `{"name":"Tom"}`,
+		},
+		{
+			name:     "html without engine",
+			accept:   "text/html",
+			val:      View{Template: "user.html"},
+			wantCode: http.StatusNotAcceptable,
+			wantErr:  ErrNotAcceptable,
+		},
+		{
+			name:     "protobuf",
+			accept:   "application/x-protobuf",
+			val:      wrapperspb.String("Tom"),
+			wantCode: http.StatusCreated,
+			wantType: "application/x-protobuf",
+			wantData: func() string {
+				data, _ := proto.Marshal(wrapperspb.String("Tom"))
+				return string(data)
+			}(),
+		},
+		{
+			name:     "not acceptable",
+			accept:   "image/png",
+			val:      respUser{Name: "Tom"},
+			wantCode: http.StatusNotAcceptable,
+			wantErr:  ErrNotAcceptable,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			recorder := httptest.NewRecorder()
+			ctx := &Context{Req: req, Resp: recorder, tplEngine: tc.engine}
+			err := ctx.Respond(http.StatusCreated, tc.val)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantCode, ctx.RespStatusCode)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tc.wantType, recorder.Header().Get("Content-Type"))
+			assert.Equal(t, tc.wantData, string(ctx.RespData))
+		})
+	}
+}
+
+func TestContext_RespJSON_ContentType(t *testing.T) {
+	var seen string
+	server := NewHTTPServer(ServerWithMiddleware(func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			next(ctx)
+			seen = ctx.Resp.Header().Get("Content-Type")
+			// middleware在业务之后依旧可以修改响应头
+			ctx.Resp.Header().Set("X-Checked", "1")
+		}
+	}))
+	server.GET("/user", func(ctx *Context) {
+		_ = ctx.RespJSON(http.StatusAccepted, respUser{Name: "Tom"})
+	})
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/user", nil))
+	assert.Equal(t, "application/json; charset=utf-8", seen)
+	assert.Equal(t, http.StatusAccepted, recorder.Code)
+	assert.Equal(t, "1", recorder.Header().Get("X-Checked"))
+	assert.Equal(t, `{"name":"Tom"}`, recorder.Body.String())
+}
+
+func TestContext_RespProblem(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	ctx := &Context{Req: httptest.NewRequest(http.MethodGet, "/", nil), Resp: recorder}
+	err := ctx.RespProblem(&Problem{
+		Status:     http.StatusNotFound,
+		Detail:     "user 12 not found",
+		Extensions: map[string]any{"user_id": 12},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, ctx.RespStatusCode)
+	assert.Equal(t, "application/problem+json", recorder.Header().Get("Content-Type"))
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(ctx.RespData, &body))
+	assert.Equal(t, map[string]any{
+		"type":    "about:blank",
+		"title":   "Not Found",
+		"status":  float64(404),
+		"detail":  "user 12 not found",
+		"user_id": float64(12),
+	}, body)
+}
+
+func TestContext_RedirectAndString(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	ctx := &Context{Req: httptest.NewRequest(http.MethodGet, "/", nil), Resp: recorder}
+	assert.Error(t, ctx.Redirect(http.StatusOK, "/login"))
+	require.NoError(t, ctx.Redirect(http.StatusSeeOther, "/login"))
+	assert.Equal(t, http.StatusSeeOther, ctx.RespStatusCode)
+	assert.Equal(t, "/login", recorder.Header().Get("Location"))
+
+	recorder = httptest.NewRecorder()
+	ctx = &Context{Req: httptest.NewRequest(http.MethodGet, "/", nil), Resp: recorder}
+	require.NoError(t, ctx.RespString(http.StatusOK, "hello"))
+	assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, "hello", string(ctx.RespData))
+}
+
+func TestContext_RespFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hello.txt")
+	require.NoError(t, os.WriteFile(path, []byte("hello world"), 0o644))
+	var size int
+	server := NewHTTPServer(ServerWithMiddleware(func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			next(ctx)
+			size = ctx.RespSize()
+		}
+	}))
+	server.GET("/file", func(ctx *Context) {
+		_ = ctx.RespFile(path)
+	})
+	server.GET("/missing", func(ctx *Context) {
+		_ = ctx.RespFile(path + ".missing")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/file", nil)
+	req.Header.Set("Range", "bytes=0-4")
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusPartialContent, recorder.Code)
+	assert.Equal(t, "hello", recorder.Body.String())
+	assert.Equal(t, 5, size)
+
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
@@ -2,16 +2,44 @@ package web
 
 import (
 	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
 )
 
 // router 用来支持对路由树的操作
 type router struct {
 	// Beego Gin HTTP method也对应一棵树，例如GET => *node
 	trees map[string]*node
+	// version 每次注册路由或者middleware都会递增，节点缓存的执行链条版本不一致时重新解析
+	version uint64
 }
 
+// anyMethods Any注册路由时使用的http方法
+var anyMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+type nodeType int
+
+// 节点类型同时也是匹配的优先级：静态匹配 > 正则匹配 > 路径参数 > 通配符
+const (
+	// 静态路由
+	nodeTypeStatic nodeType = iota
+	// 正则路由，例如 :id(^[0-9]+$)
+	nodeTypeReg
+	// 路径参数路由，例如 :id
+	nodeTypeParam
+	// 通配符路由，* 只匹配一段，*filepath 只能出现在末尾，匹配剩下的所有段
+	nodeTypeAny
+)
+
 type node struct {
+	typ nodeType
+
 	route string
 
 	path string
@@ -20,15 +48,35 @@ type node struct {
 	// 用户注册的业务逻辑
 	handler HandleFunc
 
+	// 正则匹配，命中时和路径参数一样放入pathParams
+	regChild *node
 	// 路径参数匹配
 	paramChild *node
 	// 通配符*表达的节点，任意匹配
 	starChild *node
+
+	// 路径参数、正则路由以及末尾通配符的参数名，例如 :id(^[0-9]+$) 的参数名为id，*filepath 的参数名为filepath
+	paramName string
+	// 正则路由的表达式
+	regExpr *regexp.Regexp
+
+	// 注册在当前节点上的middleware，作用于当前节点以及它下面的所有路由
+	mdls []Middleware
+	// 命中当前节点时要执行的middleware，第一次命中时从路由树中解析出来并缓存
+	matchedMdls []Middleware
+	// handler被matchedMdls包裹之后的执行链条
+	chain HandleFunc
+	// resolvedVersion 缓存对应的router.version，0表示还没有解析过
+	resolvedVersion uint64
+	resolveMutex    sync.RWMutex
 }
 
 type matchInfo struct {
 	n          *node
 	pathParams map[string]string
+	// 从路由树中解析出来的middleware，以及包裹了handler的执行链条
+	mdls  []Middleware
+	chain HandleFunc
 }
 
 func newRouter() router {
@@ -38,10 +86,13 @@ func newRouter() router {
 }
 
 // addRoute 添加一些限制，path必须以/开头，不能以/结尾，另外中间也不能有连续的 //
-func (r *router) addRoute(method string, path string, handleFunc HandleFunc) {
+// mdls会挂在path对应的节点上，handleFunc为nil时表示只注册middleware
+func (r *router) addRoute(method string, path string, handleFunc HandleFunc, mdls ...Middleware) {
 	if path == "" {
 		panic("web: 路径不能为空字符串")
 	}
+	// 新注册的middleware可能作用于已经命中过的路由，让所有缓存的执行链条失效
+	r.version++
 
 	root, ok := r.trees[method]
 	// 说明还没有root节点
@@ -61,30 +112,38 @@ func (r *router) addRoute(method string, path string, handleFunc HandleFunc) {
 
 	// 根节点特殊处理一下, "/"
 	if path == "/" {
-		if root.handler != nil {
-			panic("web: 路由冲突，重复注册[/]")
-		}
-		root.handler = handleFunc
-		root.route = "/"
+		root.setHandler("/", handleFunc, mdls)
 		return
 	}
 
 	// 切割这个path，/user/home在Split切分后，会变为["","user", "home"]
 	path = path[1:]
 	segs := strings.Split(path, "/")
-	for _, seg := range segs {
+	for i, seg := range segs {
 		if seg == "" {
 			panic("web: 不能有连续的 /")
 		}
+		if len(seg) > 1 && seg[0] == '*' && i != len(segs)-1 {
+			panic(fmt.Sprintf("web: 通配符 [%s] 只能出现在路由末尾", seg))
+		}
 		// 递归下去找位置，如果中途有节点不存在，就需要创建出来
 		child := root.childOrCreate(seg)
 		root = child
 	}
-	if root.handler != nil {
-		panic(fmt.Sprintf("web: 路由冲突，重复注册 [%s]", path))
+	root.setHandler(path, handleFunc, mdls)
+}
+
+// setHandler 在节点上挂载handler和middleware
+func (n *node) setHandler(route string, handleFunc HandleFunc, mdls []Middleware) {
+	if handleFunc != nil {
+		if n.handler != nil {
+			panic(fmt.Sprintf("web: 路由冲突，重复注册 [%s]", route))
+		}
+		n.handler = handleFunc
 	}
-	root.handler = handleFunc
-	root.route = path
+	// 只注册middleware的节点也记录下路由，用于解析middleware
+	n.route = route
+	n.mdls = append(n.mdls, mdls...)
 }
 
 func (r *router) findRoute(method string, path string) (*matchInfo, bool) {
@@ -93,53 +152,257 @@ func (r *router) findRoute(method string, path string) (*matchInfo, bool) {
 		return nil, false
 	}
 	if path == "/" {
-		return &matchInfo{
-			n: root,
-		}, true
+		return r.newMatchInfo(root, root, nil), true
 	}
 	// 把前置和后置的/都去掉
 	path = strings.Trim(path, "/")
 	// 按斜杠切割
 	segs := strings.Split(path, "/")
-	var pathParams map[string]string
-	for _, seg := range segs {
-		child, paramChild, found := root.childOf(seg)
-		// 命中了路径参数
-		if paramChild {
-			if pathParams == nil {
-				pathParams = make(map[string]string)
-			}
-			// path是:id这种格式
-			pathParams[child.path[1:]] = seg
+	state := &matchState{}
+	n, ok := root.match(segs, state)
+	if !ok {
+		return nil, false
+	}
+	if state.backtracked {
+		// 被跳过的静态节点上可能注册了middleware，例如 Use(GET, "/user/admin") 和 GET /user/:id
+		// 这时候middleware要按照请求的路径解析，结果和路由不是一一对应的，不能缓存
+		mdls := r.findMdls(root, segs)
+		return &matchInfo{
+			n:          n,
+			pathParams: state.pathParams,
+			mdls:       mdls,
+			chain:      buildChain(n.handler, mdls),
+		}, true
+	}
+	return r.newMatchInfo(root, n, state.pathParams), true
+}
+
+type matchState struct {
+	pathParams map[string]string
+	// backtracked 是否从静态节点回溯过
+	backtracked bool
+}
+
+func (s *matchState) setPathParam(name string, val string) {
+	if s.pathParams == nil {
+		s.pathParams = make(map[string]string)
+	}
+	s.pathParams[name] = val
+}
+
+// match 按照 静态匹配 > 正则匹配 > 路径参数 > 通配符 的优先级查找，最终命中的节点必须注册了handler
+// 某个子节点后面的路径匹配不上，或者它只注册了middleware，就回溯到下一种类型的子节点
+// 路径参数只在匹配成功的时候写入，回溯不会留下多余的参数
+func (n *node) match(segs []string, state *matchState) (*node, bool) {
+	if len(segs) == 0 {
+		return n, n.handler != nil
+	}
+	seg := segs[0]
+	if child, ok := n.children[seg]; ok {
+		if res, ok := child.match(segs[1:], state); ok {
+			return res, true
+		}
+		state.backtracked = true
+	}
+	if n.regChild != nil && n.regChild.regExpr.MatchString(seg) {
+		if res, ok := n.regChild.match(segs[1:], state); ok {
+			state.setPathParam(n.regChild.paramName, seg)
+			return res, true
 		}
-		if !found {
+	}
+	if n.paramChild != nil {
+		if res, ok := n.paramChild.match(segs[1:], state); ok {
+			state.setPathParam(n.paramChild.paramName, seg)
+			return res, true
+		}
+	}
+	if n.starChild == nil {
+		return nil, false
+	}
+	// 末尾通配符贪婪匹配剩下所有的段
+	if n.starChild.paramName != "" {
+		if n.starChild.handler == nil {
 			return nil, false
 		}
-		root = child
+		state.setPathParam(n.starChild.paramName, strings.Join(segs, "/"))
+		return n.starChild, true
+	}
+	return n.starChild.match(segs[1:], state)
+}
+
+// findHandler 查找注册了handler的路由，只注册了middleware的节点不算命中
+func (r *router) findHandler(method string, path string) (*matchInfo, bool) {
+	info, ok := r.findRoute(method, path)
+	if !ok || info.n.handler == nil {
+		return nil, false
+	}
+	return info, true
+}
+
+// findCaseInsensitivePath 忽略静态路由的大小写查找路由，返回以路由树中真实大小写表示的路径
+func (r *router) findCaseInsensitivePath(method string, path string) (string, bool) {
+	root, ok := r.trees[method]
+	if !ok {
+		return "", false
+	}
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+	if path == "/" {
+		segs = nil
+	}
+	res, ok := root.findCaseInsensitivePath(segs)
+	if !ok {
+		return "", false
+	}
+	return "/" + strings.Join(res, "/"), true
+}
+
+// findCaseInsensitivePath 和match的优先级一致，但是静态路由忽略大小写
+func (n *node) findCaseInsensitivePath(segs []string) ([]string, bool) {
+	if len(segs) == 0 {
+		return nil, n.handler != nil
+	}
+	seg := segs[0]
+	candidates := make([]*node, 0, 2)
+	if child, ok := n.children[seg]; ok {
+		candidates = append(candidates, child)
+	}
+	for path, child := range n.children {
+		if path != seg && strings.EqualFold(path, seg) {
+			candidates = append(candidates, child)
+		}
+	}
+	for _, child := range candidates {
+		if rest, ok := child.findCaseInsensitivePath(segs[1:]); ok {
+			return append([]string{child.path}, rest...), true
+		}
+	}
+	// 正则、路径参数和通配符节点保留请求中原本的值
+	for _, child := range []*node{n.regChild, n.paramChild, n.starChild} {
+		if child == nil || child.typ == nodeTypeReg && !child.regExpr.MatchString(seg) {
+			continue
+		}
+		if child.typ == nodeTypeAny && child.paramName != "" {
+			return segs, child.handler != nil
+		}
+		if rest, ok := child.findCaseInsensitivePath(segs[1:]); ok {
+			return append([]string{seg}, rest...), true
+		}
+	}
+	return nil, false
+}
+
+// allowedMethods 返回path能够命中的所有http方法，按照字母排序
+// 注册了GET的路由同样支持HEAD，只要有一个方法能命中就支持OPTIONS
+func (r *router) allowedMethods(path string) []string {
+	var res []string
+	for method := range r.trees {
+		if _, ok := r.findHandler(method, path); ok {
+			res = append(res, method)
+		}
+	}
+	if len(res) == 0 {
+		return nil
+	}
+	if slices.Contains(res, http.MethodGet) && !slices.Contains(res, http.MethodHead) {
+		res = append(res, http.MethodHead)
+	}
+	if !slices.Contains(res, http.MethodOptions) {
+		res = append(res, http.MethodOptions)
+	}
+	slices.Sort(res)
+	return res
+}
+
+// newMatchInfo 命中的节点第一次被访问时解析middleware和执行链条，之后的请求直接复用缓存
+// 注册了新的路由或者middleware之后缓存失效，下一次命中时重新解析
+func (r *router) newMatchInfo(treeRoot *node, n *node, pathParams map[string]string) *matchInfo {
+	n.resolveMutex.RLock()
+	resolved := n.resolvedVersion == r.version
+	mdls, chain := n.matchedMdls, n.chain
+	n.resolveMutex.RUnlock()
+	if !resolved {
+		mdls, chain = r.resolve(treeRoot, n)
 	}
 	return &matchInfo{
-		n:          root,
+		n:          n,
 		pathParams: pathParams,
-	}, true
+		mdls:       mdls,
+		chain:      chain,
+	}
+}
+
+func (r *router) resolve(treeRoot *node, n *node) ([]Middleware, HandleFunc) {
+	n.resolveMutex.Lock()
+	defer n.resolveMutex.Unlock()
+	if n.resolvedVersion == r.version {
+		return n.matchedMdls, n.chain
+	}
+	var segs []string
+	if n.route != "/" {
+		segs = strings.Split(n.route, "/")
+	}
+	n.matchedMdls = r.findMdls(treeRoot, segs)
+	n.chain = buildChain(n.handler, n.matchedMdls)
+	n.resolvedVersion = r.version
+	return n.matchedMdls, n.chain
+}
+
+// buildChain 用mdls包裹handler，handler为nil时返回nil
+func buildChain(handler HandleFunc, mdls []Middleware) HandleFunc {
+	if handler == nil {
+		return nil
+	}
+	chain := handler
+	for i := len(mdls) - 1; i >= 0; i-- {
+		chain = mdls[i](chain)
+	}
+	return chain
+}
+
+// findMdls 按照路由的每一段，找出路由树中所有能覆盖它的节点，收集这些节点上的middleware
+// 同一层中通配符节点在前、路径参数其次、静态节点最后，保证越具体的middleware越靠后执行
+func (r *router) findMdls(root *node, segs []string) []Middleware {
+	queue := []*node{root}
+	res := make([]Middleware, 0, 16)
+	res = append(res, root.mdls...)
+	for _, seg := range segs {
+		children := make([]*node, 0, len(queue))
+		for _, cur := range queue {
+			// 通配符节点上的middleware同样作用于它下面更深层的路径
+			if cur.typ == nodeTypeAny {
+				children = append(children, cur)
+			}
+			for _, child := range cur.childrenOf(seg) {
+				res = append(res, child.mdls...)
+				children = append(children, child)
+			}
+		}
+		queue = children
+	}
+	return res
 }
 
 // childOrCreate 根据seg找子节点，当子节点不存在时，进行创建
 func (n *node) childOrCreate(seg string) *node {
 	if seg[0] == ':' {
-		if n.starChild != nil {
-			panic("web: 不允许同时注册路径参数和通配符，已有通配符匹配")
+		paramName, expr, isReg := parseParam(seg)
+		if isReg {
+			return n.childOrCreateReg(seg, paramName, expr)
 		}
-		n.paramChild = &node{
-			path: seg,
-		}
-		return n.paramChild
+		return n.childOrCreateParam(seg, paramName)
 	}
-	if seg == "*" {
-		if n.paramChild != nil {
-			panic("web: 不允许同时注册路径参数和通配符，已有路径参数")
+	if seg[0] == '*' {
+		// * 和 *filepath 不能同时注册在同一个位置
+		if n.starChild != nil {
+			if n.starChild.path != seg {
+				panic(fmt.Sprintf("web: 路由冲突，已有通配符 [%s]，新注册 [%s]", n.starChild.path, seg))
+			}
+			return n.starChild
 		}
 		n.starChild = &node{
-			path: seg,
+			typ:       nodeTypeAny,
+			path:      seg,
+			paramName: seg[1:],
 		}
 		return n.starChild
 	}
@@ -158,21 +421,75 @@ func (n *node) childOrCreate(seg string) *node {
 	return res
 }
 
-// childOf 优先考虑静态匹配，匹配不上考虑通配符匹配
-// 第一个返回参数为：是否子节点，第二个返回：是否路径参数、第三个返回：是否通配符节点
-func (n *node) childOf(path string) (*node, bool, bool) {
-	if n.children == nil {
-		if n.paramChild != nil {
-			return n.paramChild, true, true
+// childOrCreateParam 同一个位置只允许有一个路径参数，例如不能同时注册 :id 和 :name
+func (n *node) childOrCreateParam(seg string, paramName string) *node {
+	if n.paramChild != nil {
+		if n.paramChild.path != seg {
+			panic(fmt.Sprintf("web: 路由冲突，已有路径参数 [%s]，新注册 [%s]", n.paramChild.path, seg))
 		}
-		return n.starChild, false, n.starChild != nil
+		return n.paramChild
 	}
-	res, ok := n.children[path]
-	if !ok {
-		if n.paramChild != nil {
-			return n.paramChild, true, true
+	n.paramChild = &node{
+		typ:       nodeTypeParam,
+		path:      seg,
+		paramName: paramName,
+	}
+	return n.paramChild
+}
+
+// childOrCreateReg 同一个位置只允许有一个正则路由，参数名和表达式都必须一致
+func (n *node) childOrCreateReg(seg string, paramName string, expr string) *node {
+	if n.regChild != nil {
+		if n.regChild.path != seg {
+			panic(fmt.Sprintf("web: 路由冲突，已有正则路由 [%s]，新注册 [%s]", n.regChild.path, seg))
+		}
+		return n.regChild
+	}
+	regExpr, err := regexp.Compile(expr)
+	if err != nil {
+		panic(fmt.Sprintf("web: 正则表达式错误 [%s]: %v", seg, err))
+	}
+	n.regChild = &node{
+		typ:       nodeTypeReg,
+		path:      seg,
+		paramName: paramName,
+		regExpr:   regExpr,
+	}
+	return n.regChild
+}
+
+// parseParam 解析路径参数，:id(^[0-9]+$) 会被解析为参数名id以及表达式^[0-9]+$
+// 表达式中不能包含/，因为路由是先按照/切割再解析的
+func parseParam(seg string) (string, string, bool) {
+	start := strings.Index(seg, "(")
+	if start < 0 {
+		if len(seg) == 1 {
+			panic(fmt.Sprintf("web: 路径参数缺少参数名 [%s]", seg))
 		}
-		return n.starChild, false, n.starChild != nil
+		return seg[1:], "", false
+	}
+	if seg[len(seg)-1] != ')' || start == 1 || start == len(seg)-2 {
+		panic(fmt.Sprintf("web: 非法的正则路由 [%s]，格式应为 :name(expr)", seg))
 	}
-	return res, false, ok
+	return seg[1:start], seg[start+1 : len(seg)-1], true
+}
+
+// childrenOf 返回能覆盖路由片段seg的所有子节点，路径参数节点可以覆盖静态片段、正则片段和路径参数片段
+// 正则节点可以覆盖能被它匹配的静态片段以及同样的正则片段
+func (n *node) childrenOf(seg string) []*node {
+	res := make([]*node, 0, 4)
+	if n.starChild != nil {
+		res = append(res, n.starChild)
+	}
+	if n.paramChild != nil && seg[0] != '*' {
+		res = append(res, n.paramChild)
+	}
+	if n.regChild != nil && (seg == n.regChild.path ||
+		seg[0] != ':' && seg[0] != '*' && n.regChild.regExpr.MatchString(seg)) {
+		res = append(res, n.regChild)
+	}
+	if static, ok := n.children[seg]; ok {
+		res = append(res, static)
+	}
+	return res
 }
@@ -3,6 +3,7 @@ package web
 import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"net/http"
 	"reflect"
 	"testing"
@@ -89,8 +90,10 @@ func TestRouter_addRouter(t *testing.T) {
 								path:    "detail",
 								handler: mockHandler,
 								paramChild: &node{
-									path:    ":id",
-									handler: mockHandler,
+									typ:       nodeTypeParam,
+									path:      ":id",
+									paramName: "id",
+									handler:   mockHandler,
 								},
 							},
 						},
@@ -109,6 +112,7 @@ func TestRouter_addRouter(t *testing.T) {
 							},
 						},
 						starChild: &node{
+							typ:     nodeTypeAny,
 							path:    "*",
 							handler: mockHandler,
 						},
@@ -148,9 +152,18 @@ func (r *router) equal(y *router) (string, bool) {
 }
 
 func (n *node) equal(y *node) (string, bool) {
+	if y == nil {
+		return fmt.Sprintf("目标节点为nil"), false
+	}
+	if n.typ != y.typ {
+		return fmt.Sprintf("节点类型不匹配"), false
+	}
 	if n.path != y.path {
 		return fmt.Sprintf("节点路径不匹配"), false
 	}
+	if n.paramName != y.paramName {
+		return fmt.Sprintf("参数名不匹配"), false
+	}
 	if len(n.children) != len(y.children) {
 		return fmt.Sprintf("子节点数量不相等"), false
 	}
@@ -168,6 +181,20 @@ func (n *node) equal(y *node) (string, bool) {
 		}
 	}
 
+	if n.regChild != nil {
+		if n.regChild.regExpr.String() != y.regChild.regExpr.String() {
+			return fmt.Sprintf("正则表达式不匹配"), false
+		}
+		msg, ok := n.regChild.equal(y.regChild)
+		if !ok {
+			return msg, ok
+		}
+	}
+
+	if len(n.mdls) != len(y.mdls) {
+		return fmt.Sprintf("middleware数量不相等"), false
+	}
+
 	// 比较handler
 	nhandler := reflect.ValueOf(n.handler)
 	yhandler := reflect.ValueOf(y.handler)
@@ -258,3 +285,302 @@ func TestRouter_findRoute(t *testing.T) {
 		})
 	}
 }
+
+func TestRouter_findMdls(t *testing.T) {
+	mdlBuilder := func(name string) Middleware {
+		return func(next HandleFunc) HandleFunc {
+			return func(ctx *Context) {
+				ctx.RespData = append(ctx.RespData, name...)
+				next(ctx)
+			}
+		}
+	}
+	var mockHandler HandleFunc = func(ctx *Context) {}
+
+	r := newRouter()
+	r.addRoute(http.MethodGet, "/", nil, mdlBuilder("root;"))
+	r.addRoute(http.MethodGet, "/user", mockHandler, mdlBuilder("user;"))
+	r.addRoute(http.MethodGet, "/user/*", nil, mdlBuilder("user/*;"))
+	r.addRoute(http.MethodGet, "/user/:id", nil, mdlBuilder("user/:id;"))
+	r.addRoute(http.MethodGet, "/user/:id/profile", mockHandler, mdlBuilder("profile;"))
+	r.addRoute(http.MethodGet, "/user/home", mockHandler)
+	r.addRoute(http.MethodGet, "/order/detail", mockHandler, mdlBuilder("detail1;"), mdlBuilder("detail2;"))
+
+	testCases := []struct {
+		name     string
+		path     string
+		wantData string
+	}{
+		{
+			name:     "root",
+			path:     "/user",
+			wantData: "root;user;",
+		},
+		{
+			name:     "static",
+			path:     "/user/home",
+			wantData: "root;user;user/*;user/:id;",
+		},
+		{
+			name:     "star and param",
+			path:     "/user/123/profile",
+			wantData: "root;user;user/*;user/:id;profile;",
+		},
+		{
+			name:     "multiple",
+			path:     "/order/detail",
+			wantData: "root;detail1;detail2;",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			info, found := r.findRoute(http.MethodGet, tc.path)
+			assert.True(t, found)
+			ctx := &Context{}
+			info.chain(ctx)
+			assert.Equal(t, tc.wantData, string(ctx.RespData))
+			// 第二次命中直接使用缓存的执行链条
+			again, _ := r.findRoute(http.MethodGet, tc.path)
+			assert.Equal(t, reflect.ValueOf(info.chain), reflect.ValueOf(again.chain))
+		})
+	}
+}
+
+func TestRouter_regexRoute(t *testing.T) {
+	var mockHandler HandleFunc = func(ctx *Context) {}
+	r := newRouter()
+	r.addRoute(http.MethodGet, "/order/new", mockHandler)
+	r.addRoute(http.MethodGet, "/order/:id(^[0-9]+$)", mockHandler)
+	r.addRoute(http.MethodGet, "/order/:name", mockHandler)
+	r.addRoute(http.MethodGet, "/order/*", mockHandler)
+	r.addRoute(http.MethodGet, "/order/:id(^[0-9]+$)/detail", mockHandler)
+
+	testCases := []struct {
+		name           string
+		path           string
+		wantFound      bool
+		wantRoute      string
+		wantPathParams map[string]string
+	}{
+		{
+			name:      "static first",
+			path:      "/order/new",
+			wantFound: true,
+			wantRoute: "order/new",
+		},
+		{
+			name:           "regex",
+			path:           "/order/123",
+			wantFound:      true,
+			wantRoute:      "order/:id(^[0-9]+$)",
+			wantPathParams: map[string]string{"id": "123"},
+		},
+		{
+			name:           "regex not match, fallback to param",
+			path:           "/order/abc",
+			wantFound:      true,
+			wantRoute:      "order/:name",
+			wantPathParams: map[string]string{"name": "abc"},
+		},
+		{
+			name:           "regex with children",
+			path:           "/order/123/detail",
+			wantFound:      true,
+			wantRoute:      "order/:id(^[0-9]+$)/detail",
+			wantPathParams: map[string]string{"id": "123"},
+		},
+		{
+			// 回溯到路径参数和通配符之后依旧匹配不上
+			name: "backtrack not found",
+			path: "/order/123/other",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			info, found := r.findRoute(http.MethodGet, tc.path)
+			assert.Equal(t, tc.wantFound, found)
+			if !found {
+				return
+			}
+			assert.Equal(t, tc.wantRoute, info.n.route)
+			assert.Equal(t, tc.wantPathParams, info.pathParams)
+		})
+	}
+
+	// 只有正则路由时，匹配不上就是404
+	r = newRouter()
+	r.addRoute(http.MethodGet, "/user/:id(^[0-9]+$)", mockHandler)
+	_, found := r.findRoute(http.MethodGet, "/user/abc")
+	assert.False(t, found)
+
+	// 冲突检测
+	assert.Panics(t, func() {
+		r.addRoute(http.MethodGet, "/user/:uid([0-9]+)", mockHandler)
+	})
+	assert.Panics(t, func() {
+		r.addRoute(http.MethodGet, "/user/:id(^[a-z]+$)", mockHandler)
+	})
+	assert.Panics(t, func() {
+		r.addRoute(http.MethodGet, "/item/:id([0-9]+", mockHandler)
+	})
+	assert.Panics(t, func() {
+		r.addRoute(http.MethodGet, "/item/:id([0-9)", mockHandler)
+	})
+	r.addRoute(http.MethodGet, "/item/:id", mockHandler)
+	assert.Panics(t, func() {
+		r.addRoute(http.MethodGet, "/item/:name", mockHandler)
+	})
+}
+
+func TestRouter_backtrack(t *testing.T) {
+	mdl := func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			ctx.RespData = append(ctx.RespData, "admin;"...)
+			next(ctx)
+		}
+	}
+	var paramHandler HandleFunc = func(ctx *Context) {
+		ctx.RespData = append(ctx.RespData, "param"...)
+	}
+	var mockHandler HandleFunc = func(ctx *Context) {}
+	r := newRouter()
+	r.addRoute(http.MethodGet, "/user/:id", paramHandler)
+	r.addRoute(http.MethodGet, "/user/admin", nil, mdl)
+	r.addRoute(http.MethodGet, "/order/:id(^[0-9]+$)/detail", mockHandler)
+	r.addRoute(http.MethodGet, "/order/:name/items", mockHandler)
+	r.addRoute(http.MethodGet, "/file/static/index", mockHandler)
+	r.addRoute(http.MethodGet, "/file/*filepath", mockHandler)
+
+	testCases := []struct {
+		name           string
+		path           string
+		wantRoute      string
+		wantPathParams map[string]string
+	}{
+		{
+			// 只注册了middleware的静态节点不会挡住路径参数
+			name:           "middleware only static",
+			path:           "/user/admin",
+			wantRoute:      "user/:id",
+			wantPathParams: map[string]string{"id": "admin"},
+		},
+		{
+			name:           "regex to param",
+			path:           "/order/123/items",
+			wantRoute:      "order/:name/items",
+			wantPathParams: map[string]string{"name": "123"},
+		},
+		{
+			name:           "static to catch all",
+			path:           "/file/static/app.js",
+			wantRoute:      "file/*filepath",
+			wantPathParams: map[string]string{"filepath": "static/app.js"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			info, found := r.findRoute(http.MethodGet, tc.path)
+			require.True(t, found)
+			assert.Equal(t, tc.wantRoute, info.n.route)
+			assert.Equal(t, tc.wantPathParams, info.pathParams)
+		})
+	}
+
+	// 命中的是 :id，admin节点上的middleware同样会执行
+	info, _ := r.findRoute(http.MethodGet, "/user/admin")
+	ctx := &Context{}
+	info.chain(ctx)
+	assert.Equal(t, "admin;param", string(ctx.RespData))
+}
+
+func TestRouter_invalidateChain(t *testing.T) {
+	mdlBuilder := func(name string) Middleware {
+		return func(next HandleFunc) HandleFunc {
+			return func(ctx *Context) {
+				ctx.RespData = append(ctx.RespData, name...)
+				next(ctx)
+			}
+		}
+	}
+	r := newRouter()
+	r.addRoute(http.MethodGet, "/user/home", func(ctx *Context) {})
+	info, _ := r.findRoute(http.MethodGet, "/user/home")
+	ctx := &Context{}
+	info.chain(ctx)
+	assert.Empty(t, ctx.RespData)
+
+	// 已经命中过之后再注册的middleware同样生效
+	r.addRoute(http.MethodGet, "/user", nil, mdlBuilder("user;"))
+	info, _ = r.findRoute(http.MethodGet, "/user/home")
+	ctx = &Context{}
+	info.chain(ctx)
+	assert.Equal(t, "user;", string(ctx.RespData))
+}
+
+func TestRouter_catchAllRoute(t *testing.T) {
+	var mockHandler HandleFunc = func(ctx *Context) {}
+	r := newRouter()
+	r.addRoute(http.MethodGet, "/static/*filepath", mockHandler)
+	r.addRoute(http.MethodGet, "/static/index", mockHandler)
+	r.addRoute(http.MethodGet, "/img/*", mockHandler)
+
+	testCases := []struct {
+		name           string
+		path           string
+		wantFound      bool
+		wantRoute      string
+		wantPathParams map[string]string
+	}{
+		{
+			name:           "one segment",
+			path:           "/static/app.js",
+			wantFound:      true,
+			wantRoute:      "static/*filepath",
+			wantPathParams: map[string]string{"filepath": "app.js"},
+		},
+		{
+			name:           "multiple segments",
+			path:           "/static/css/app/main.css",
+			wantFound:      true,
+			wantRoute:      "static/*filepath",
+			wantPathParams: map[string]string{"filepath": "css/app/main.css"},
+		},
+		{
+			name:      "static first",
+			path:      "/static/index",
+			wantFound: true,
+			wantRoute: "static/index",
+		},
+		{
+			name:      "star one segment",
+			path:      "/img/a.png",
+			wantFound: true,
+			wantRoute: "img/*",
+		},
+		{
+			name: "star not catch all",
+			path: "/img/a/b.png",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			info, found := r.findRoute(http.MethodGet, tc.path)
+			assert.Equal(t, tc.wantFound, found)
+			if !found {
+				return
+			}
+			assert.Equal(t, tc.wantRoute, info.n.route)
+			assert.Equal(t, tc.wantPathParams, info.pathParams)
+		})
+	}
+
+	assert.Panics(t, func() {
+		r.addRoute(http.MethodGet, "/static/*", mockHandler)
+	})
+	assert.Panics(t, func() {
+		r.addRoute(http.MethodGet, "/assets/*filepath/detail", mockHandler)
+	})
+}
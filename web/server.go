@@ -1,9 +1,15 @@
 package web
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net"
 	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 type HandleFunc func(ctx *Context)
@@ -12,8 +18,8 @@ type Server interface {
 	http.Handler
 	Start(add string) error
 
-	// AddRoute 路由注册, method是http方法、path是路由、handleFunc是业务逻辑
-	addRoute(method string, path string, handleFunc HandleFunc)
+	// AddRoute 路由注册, method是http方法、path是路由、handleFunc是业务逻辑、mdls是挂在路由上的middleware
+	addRoute(method string, path string, handleFunc HandleFunc, mdls ...Middleware)
 
 	// AddRouteVarFuncs 没有必要去提供，多个函数中断执行、方法优先级etc问题
 	// Deprecated
@@ -32,6 +38,32 @@ type HTTPServer struct {
 	log func(msg string, args ...any)
 
 	tplEngine TemplateEngine
+
+	// 路由没有命中时执行，和业务逻辑一样处于middleware链条之内
+	notFoundHandler HandleFunc
+	// 路由在其它http方法下存在时执行，执行之前已经设置好了Allow头
+	methodNotAllowedHandler HandleFunc
+
+	// 请求 /users/ 时重定向到 /users
+	redirectTrailingSlash bool
+	// 请求 //Users 这种有连续的/或者大小写不一致的路径时，重定向到路由树中真实的路径
+	redirectFixedPath bool
+
+	// server 启动之后才有值，Shutdown的时候使用
+	server *http.Server
+	mutex  sync.Mutex
+	// 处于draining状态时，新的请求直接返回503
+	draining   atomic.Bool
+	startHooks []Hook
+	stopHooks  []Hook
+
+	tlsConfig      *tls.Config
+	getCertificate func(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+	// 双向TLS认证
+	clientCAs  *x509.CertPool
+	clientAuth tls.ClientAuthType
+	// 明文的HTTP/2
+	h2c bool
 }
 
 func NewHTTPServer(opts ...HTTPServerOption) *HTTPServer {
@@ -40,6 +72,14 @@ func NewHTTPServer(opts ...HTTPServerOption) *HTTPServer {
 		log: func(msg string, args ...any) {
 			fmt.Printf(msg, args...)
 		},
+		notFoundHandler: func(ctx *Context) {
+			ctx.RespStatusCode = http.StatusNotFound
+			ctx.RespData = []byte("not found")
+		},
+		methodNotAllowedHandler: func(ctx *Context) {
+			ctx.RespStatusCode = http.StatusMethodNotAllowed
+			ctx.RespData = []byte("method not allowed")
+		},
 	}
 	for _, opt := range opts {
 		opt(res)
@@ -59,6 +99,35 @@ func ServerWithTemplateEngine(tplEngine TemplateEngine) HTTPServerOption {
 	}
 }
 
+// ServerWithNotFoundHandler 路由没有命中时的处理逻辑，access log、metrics等middleware同样能观测到
+func ServerWithNotFoundHandler(hdl HandleFunc) HTTPServerOption {
+	return func(server *HTTPServer) {
+		server.notFoundHandler = hdl
+	}
+}
+
+// ServerWithMethodNotAllowedHandler 路由在其它http方法下存在时的处理逻辑
+func ServerWithMethodNotAllowedHandler(hdl HandleFunc) HTTPServerOption {
+	return func(server *HTTPServer) {
+		server.methodNotAllowedHandler = hdl
+	}
+}
+
+// ServerWithRedirectTrailingSlash 开启之后，请求 /users/ 会被重定向到 /users
+// 没有开启时，/users/ 直接命中 /users
+func ServerWithRedirectTrailingSlash() HTTPServerOption {
+	return func(server *HTTPServer) {
+		server.redirectTrailingSlash = true
+	}
+}
+
+// ServerWithRedirectFixedPath 开启之后，有连续的/、. 和 .. 或者大小写不一致的路径会被重定向到修正之后的路径
+func ServerWithRedirectFixedPath() HTTPServerOption {
+	return func(server *HTTPServer) {
+		server.redirectFixedPath = true
+	}
+}
+
 // SetMiddlewares Deprecated，方法不优雅，代码太硬没有设计
 func (h *HTTPServer) SetMiddlewares(middlewares []Middleware) {
 	h.mids = middlewares
@@ -69,8 +138,14 @@ func (h *HTTPServer) AddRouteVarFuncs(method string, path string, handleFunc ...
 	panic("implement me")
 }
 
-func (h *HTTPServer) addRoute(method string, path string, handleFunc HandleFunc) {
-	h.router.addRoute(method, path, handleFunc)
+func (h *HTTPServer) addRoute(method string, path string, handleFunc HandleFunc, mdls ...Middleware) {
+	h.router.addRoute(method, path, handleFunc, mdls...)
+}
+
+// Use 在路由树的path节点上注册middleware，作用于path以及它下面的所有路由
+// 例如注册在/user/*上的middleware，对/user/:id/profile同样生效
+func (h *HTTPServer) Use(method string, path string, mdls ...Middleware) {
+	h.addRoute(method, path, nil, mdls...)
 }
 
 // GET 请求，在HTTPServer中实现
@@ -83,8 +158,36 @@ func (h *HTTPServer) POST(path string, handleFunc HandleFunc) {
 	h.addRoute(http.MethodPost, path, handleFunc)
 }
 
+// OPTIONS 请求，没有注册时由HTTPServer自动应答，返回Allow头
 func (h *HTTPServer) OPTIONS(path string, handleFunc HandleFunc) {
-	h.addRoute(http.MethodPost, path, handleFunc)
+	h.addRoute(http.MethodOptions, path, handleFunc)
+}
+
+// PUT 请求，在HTTPServer中实现
+func (h *HTTPServer) PUT(path string, handleFunc HandleFunc) {
+	h.addRoute(http.MethodPut, path, handleFunc)
+}
+
+// DELETE 请求，在HTTPServer中实现
+func (h *HTTPServer) DELETE(path string, handleFunc HandleFunc) {
+	h.addRoute(http.MethodDelete, path, handleFunc)
+}
+
+// PATCH 请求，在HTTPServer中实现
+func (h *HTTPServer) PATCH(path string, handleFunc HandleFunc) {
+	h.addRoute(http.MethodPatch, path, handleFunc)
+}
+
+// HEAD 请求，没有注册时会使用GET路由来处理，并且不返回响应体
+func (h *HTTPServer) HEAD(path string, handleFunc HandleFunc) {
+	h.addRoute(http.MethodHead, path, handleFunc)
+}
+
+// Any 在所有常用的http方法上注册同一个handleFunc
+func (h *HTTPServer) Any(path string, handleFunc HandleFunc) {
+	for _, method := range anyMethods {
+		h.addRoute(method, path, handleFunc)
+	}
 }
 
 // ServeHTTP HTTPServer 处理请求入口
@@ -95,6 +198,9 @@ func (h *HTTPServer) ServeHTTP(writer http.ResponseWriter, request *http.Request
 		tplEngine: h.tplEngine,
 	}
 	root := h.serve
+	if h.draining.Load() {
+		root = h.serveDraining
+	}
 	// 然后这里就是调用最后一个不断向前回溯的组装链条，从后往前构造一个链条
 	for i := len(h.mids) - 1; i >= 0; i-- {
 		root = h.mids[i](root)
@@ -112,9 +218,17 @@ func (h *HTTPServer) ServeHTTP(writer http.ResponseWriter, request *http.Request
 }
 
 func (h *HTTPServer) flashResp(ctx *Context) {
+	// 流式响应已经把数据写出去了，被接管的连接也不能再写
+	if ctx.streaming || ctx.hijacked {
+		return
+	}
 	if ctx.RespStatusCode != 0 {
 		ctx.Resp.WriteHeader(ctx.RespStatusCode)
 	}
+	// HEAD请求只返回响应头，没有响应体时也不需要写，例如204
+	if ctx.Req.Method == http.MethodHead || len(ctx.RespData) == 0 {
+		return
+	}
 	n, err := ctx.Resp.Write(ctx.RespData)
 	if err != nil || n != len(ctx.RespData) {
 		h.log("写入响应失败... %v", err)
@@ -123,18 +237,90 @@ func (h *HTTPServer) flashResp(ctx *Context) {
 
 // serve 查找路由，执行实际的业务逻辑
 func (h *HTTPServer) serve(ctx *Context) {
-	info, ok := h.findRoute(ctx.Req.Method, ctx.Req.URL.Path)
-	if !ok || info.n.handler == nil {
-		// 路由没有命中，就是404
-		/*ctx.Resp.WriteHeader(404)
-		_, _ = ctx.Resp.Write([]byte("not found"))*/
-		ctx.RespStatusCode = 404
-		ctx.RespData = []byte("not found")
+	method, path := ctx.Req.Method, ctx.Req.URL.Path
+	info, ok := h.findMethodHandler(method, path)
+	// 已经命中的路径不需要修正，只有没有命中时才尝试重定向
+	// 路由树匹配时忽略了末尾的/，所以开启redirectTrailingSlash时末尾带/的路径同样需要重定向
+	trailingSlash := h.redirectTrailingSlash && path != "/" && strings.HasSuffix(path, "/")
+	if (!ok || trailingSlash) && h.redirect(ctx) {
+		return
+	}
+	if !ok {
+		allowed := h.allowedMethods(path)
+		if len(allowed) == 0 {
+			// 路由没有命中，就是404
+			h.notFoundHandler(ctx)
+			return
+		}
+		ctx.Resp.Header().Set("Allow", strings.Join(allowed, ", "))
+		// OPTIONS请求没有单独注册时，自动应答
+		if method == http.MethodOptions {
+			ctx.RespStatusCode = http.StatusNoContent
+			return
+		}
+		// 路由在其它http方法下存在，就是405
+		h.methodNotAllowedHandler(ctx)
 		return
 	}
 	ctx.PathParams = info.pathParams
 	ctx.MatchedRoute = info.n.route
-	info.n.handler(ctx)
+	// 执行路由树上的middleware以及业务逻辑
+	info.chain(ctx)
+}
+
+// findMethodHandler 查找method对应的路由，HEAD请求没有单独注册时，使用GET路由来处理
+func (h *HTTPServer) findMethodHandler(method string, path string) (*matchInfo, bool) {
+	info, ok := h.findHandler(method, path)
+	if !ok && method == http.MethodHead {
+		info, ok = h.findHandler(http.MethodGet, path)
+	}
+	return info, ok
+}
+
+// redirect 请求路径没有命中路由时，按照重定向策略修正请求路径，修正之后的路径能命中路由时重定向过去
+// GET、HEAD使用301，其它方法使用308以保证客户端不会改变method和请求体
+func (h *HTTPServer) redirect(ctx *Context) bool {
+	if !h.redirectTrailingSlash && !h.redirectFixedPath {
+		return false
+	}
+	method, reqPath := ctx.Req.Method, ctx.Req.URL.Path
+	if reqPath == "/" {
+		return false
+	}
+	fixed := reqPath
+	if h.redirectFixedPath {
+		fixed = path.Clean(reqPath)
+		// path.Clean会去掉末尾的/，是否去掉由redirectTrailingSlash决定
+		if fixed != "/" && strings.HasSuffix(reqPath, "/") {
+			fixed += "/"
+		}
+	}
+	if h.redirectTrailingSlash && fixed != "/" {
+		fixed = strings.TrimSuffix(fixed, "/")
+	}
+	_, ok := h.findMethodHandler(method, fixed)
+	if !ok && h.redirectFixedPath {
+		// 大小写不一致的情况，查找失败时返回的是空字符串，HEAD回退到GET时需要用原来的路径
+		candidate := fixed
+		fixed, ok = h.findCaseInsensitivePath(method, candidate)
+		if !ok && method == http.MethodHead {
+			fixed, ok = h.findCaseInsensitivePath(http.MethodGet, candidate)
+		}
+	}
+	if !ok || fixed == reqPath {
+		return false
+	}
+
+	if ctx.Req.URL.RawQuery != "" {
+		fixed = fixed + "?" + ctx.Req.URL.RawQuery
+	}
+	ctx.Resp.Header().Set("Location", fixed)
+	if method == http.MethodGet || method == http.MethodHead {
+		ctx.RespStatusCode = http.StatusMovedPermanently
+	} else {
+		ctx.RespStatusCode = http.StatusPermanentRedirect
+	}
+	return true
 }
 
 func (h *HTTPServer) Start(addr string) error {
@@ -143,5 +329,5 @@ func (h *HTTPServer) Start(addr string) error {
 		return err
 	}
 	// 与直接调用http.ListenAndServe(":8081", h)相比，使用HTTPServer可以注册callback function
-	return http.Serve(listener, h)
+	return h.Serve(listener)
 }
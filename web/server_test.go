@@ -0,0 +1,240 @@
+package web
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPServer_methods(t *testing.T) {
+	server := NewHTTPServer()
+	handler := func(ctx *Context) {
+		ctx.RespStatusCode = http.StatusOK
+		ctx.RespData = []byte(ctx.Req.Method + " " + ctx.MatchedRoute)
+	}
+	server.GET("/user", handler)
+	server.POST("/user", handler)
+	server.PUT("/user/:id", handler)
+	server.PATCH("/user/:id", handler)
+	server.DELETE("/user/:id", handler)
+	server.OPTIONS("/order", handler)
+	server.HEAD("/order", handler)
+	server.Any("/any", handler)
+
+	testCases := []struct {
+		name      string
+		method    string
+		path      string
+		wantCode  int
+		wantAllow string
+		wantBody  string
+	}{
+		{
+			name:     "put",
+			method:   http.MethodPut,
+			path:     "/user/1",
+			wantCode: http.StatusOK,
+			wantBody: "PUT user/:id",
+		},
+		{
+			name:     "patch",
+			method:   http.MethodPatch,
+			path:     "/user/1",
+			wantCode: http.StatusOK,
+			wantBody: "PATCH user/:id",
+		},
+		{
+			name:     "delete",
+			method:   http.MethodDelete,
+			path:     "/user/1",
+			wantCode: http.StatusOK,
+			wantBody: "DELETE user/:id",
+		},
+		{
+			// HEAD由GET路由处理，但是不返回响应体
+			name:     "head from get",
+			method:   http.MethodHead,
+			path:     "/user",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:      "auto options",
+			method:    http.MethodOptions,
+			path:      "/user",
+			wantCode:  http.StatusNoContent,
+			wantAllow: "GET, HEAD, OPTIONS, POST",
+		},
+		{
+			name:     "registered options",
+			method:   http.MethodOptions,
+			path:     "/order",
+			wantCode: http.StatusOK,
+			wantBody: "OPTIONS order",
+		},
+		{
+			name:      "method not allowed",
+			method:    http.MethodGet,
+			path:      "/user/1",
+			wantCode:  http.StatusMethodNotAllowed,
+			wantAllow: "DELETE, OPTIONS, PATCH, PUT",
+			wantBody:  "method not allowed",
+		},
+		{
+			name:     "not found",
+			method:   http.MethodGet,
+			path:     "/not/found",
+			wantCode: http.StatusNotFound,
+			wantBody: "not found",
+		},
+		{
+			name:     "any",
+			method:   http.MethodPatch,
+			path:     "/any",
+			wantCode: http.StatusOK,
+			wantBody: "PATCH any",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, httptest.NewRequest(tc.method, tc.path, nil))
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantAllow, recorder.Header().Get("Allow"))
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+		})
+	}
+}
+
+func TestHTTPServer_notFoundHandler(t *testing.T) {
+	var routes []string
+	logMdl := func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			next(ctx)
+			routes = append(routes, ctx.Req.URL.Path)
+		}
+	}
+	server := NewHTTPServer(ServerWithMiddleware(logMdl),
+		ServerWithNotFoundHandler(func(ctx *Context) {
+			ctx.RespStatusCode = http.StatusNotFound
+			ctx.RespData = []byte("custom not found")
+		}),
+		ServerWithMethodNotAllowedHandler(func(ctx *Context) {
+			ctx.RespStatusCode = http.StatusMethodNotAllowed
+			ctx.RespData = []byte("custom method not allowed: " + ctx.Resp.Header().Get("Allow"))
+		}))
+	server.POST("/user", func(ctx *Context) {})
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/order", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assert.Equal(t, "custom not found", recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/user", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+	assert.Equal(t, "custom method not allowed: OPTIONS, POST", recorder.Body.String())
+
+	// 两个请求都经过了middleware
+	assert.Equal(t, []string{"/order", "/user"}, routes)
+}
+
+func TestHTTPServer_redirect(t *testing.T) {
+	handler := func(ctx *Context) {
+		ctx.RespStatusCode = http.StatusOK
+		ctx.RespData = []byte(ctx.MatchedRoute)
+	}
+	testCases := []struct {
+		name         string
+		opts         []HTTPServerOption
+		method       string
+		path         string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			// 没有开启重定向时，/users/ 直接命中 /users
+			name:     "no redirect",
+			method:   http.MethodGet,
+			path:     "/users/",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:         "trailing slash",
+			opts:         []HTTPServerOption{ServerWithRedirectTrailingSlash()},
+			method:       http.MethodGet,
+			path:         "/users/?page=1",
+			wantCode:     http.StatusMovedPermanently,
+			wantLocation: "/users?page=1",
+		},
+		{
+			name:         "trailing slash post",
+			opts:         []HTTPServerOption{ServerWithRedirectTrailingSlash()},
+			method:       http.MethodPost,
+			path:         "/users/",
+			wantCode:     http.StatusPermanentRedirect,
+			wantLocation: "/users",
+		},
+		{
+			name:     "trailing slash not found",
+			opts:     []HTTPServerOption{ServerWithRedirectTrailingSlash()},
+			method:   http.MethodGet,
+			path:     "/orders/",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:         "duplicate slashes",
+			opts:         []HTTPServerOption{ServerWithRedirectFixedPath()},
+			method:       http.MethodGet,
+			path:         "//users/../users/1/profile",
+			wantCode:     http.StatusMovedPermanently,
+			wantLocation: "/users/1/profile",
+		},
+		{
+			name:         "case",
+			opts:         []HTTPServerOption{ServerWithRedirectFixedPath()},
+			method:       http.MethodGet,
+			path:         "/USERS/Tom/PROFILE",
+			wantCode:     http.StatusMovedPermanently,
+			wantLocation: "/users/Tom/profile",
+		},
+		{
+			name:         "case head",
+			opts:         []HTTPServerOption{ServerWithRedirectFixedPath()},
+			method:       http.MethodHead,
+			path:         "/Users",
+			wantCode:     http.StatusMovedPermanently,
+			wantLocation: "/users",
+		},
+		{
+			// 已经命中的路径不会被修正
+			name:     "matched not cleaned",
+			opts:     []HTTPServerOption{ServerWithRedirectFixedPath()},
+			method:   http.MethodGet,
+			path:     "/files/a/../b",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "case not found",
+			opts:     []HTTPServerOption{ServerWithRedirectFixedPath()},
+			method:   http.MethodGet,
+			path:     "/USERS/Tom/other",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := NewHTTPServer(tc.opts...)
+			server.GET("/users", handler)
+			server.POST("/users", handler)
+			server.GET("/users/:name/profile", handler)
+			server.GET("/files/*path", handler)
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, httptest.NewRequest(tc.method, tc.path, nil))
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantLocation, recorder.Header().Get("Location"))
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Codec 把任意类型的值编码成字符串保存在session里面
+type Codec interface {
+	Encode(val any) (string, error)
+	Decode(data string, val any) error
+}
+
+// DefaultCodec Get[T]和Set[T]使用的Codec
+var DefaultCodec Codec = JSONCodec{}
+
+// JSONCodec 使用JSON编码，字符串保持原样，和Session.Get、Session.Set保存的值互通
+type JSONCodec struct{}
+
+func (JSONCodec) Encode(val any) (string, error) {
+	if str, ok := val.(string); ok {
+		return str, nil
+	}
+	data, err := json.Marshal(val)
+	return string(data), err
+}
+
+func (JSONCodec) Decode(data string, val any) error {
+	if str, ok := val.(*string); ok {
+		*str = data
+		return nil
+	}
+	return json.Unmarshal([]byte(data), val)
+}
+
+// Get 读取key并且解码成T，例如
+//
+//	user, err := session.Get[User](ctx, sess, "user")
+func Get[T any](ctx context.Context, sess Session, key string) (T, error) {
+	return GetWithCodec[T](ctx, sess, DefaultCodec, key)
+}
+
+// Set 使用DefaultCodec编码之后保存
+func Set[T any](ctx context.Context, sess Session, key string, val T) error {
+	return SetWithCodec(ctx, sess, DefaultCodec, key, val)
+}
+
+func GetWithCodec[T any](ctx context.Context, sess Session, codec Codec, key string) (T, error) {
+	var res T
+	data, err := sess.Get(ctx, key)
+	if err != nil {
+		return res, err
+	}
+	err = codec.Decode(data, &res)
+	return res, err
+}
+
+func SetWithCodec[T any](ctx context.Context, sess Session, codec Codec, key string, val T) error {
+	data, err := codec.Encode(val)
+	if err != nil {
+		return err
+	}
+	return sess.Set(ctx, key, data)
+}
@@ -0,0 +1,40 @@
+package session_test
+
+import (
+	"context"
+	"github.com/dongma/imola/web/session"
+	"github.com/dongma/imola/web/session/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestGetSet(t *testing.T) {
+	ctx := context.Background()
+	sess, err := memory.NewStore(time.Minute).Generate(ctx, "sess-1")
+	require.NoError(t, err)
+
+	type cart struct {
+		Items []string
+		Total float64
+	}
+	require.NoError(t, session.Set(ctx, sess, "cart", cart{Items: []string{"apple"}, Total: 1.5}))
+	c, err := session.Get[cart](ctx, sess, "cart")
+	require.NoError(t, err)
+	assert.Equal(t, cart{Items: []string{"apple"}, Total: 1.5}, c)
+
+	require.NoError(t, session.Set(ctx, sess, "count", 3))
+	cnt, err := session.Get[int](ctx, sess, "count")
+	require.NoError(t, err)
+	assert.Equal(t, 3, cnt)
+
+	// 字符串保持原样，和Session.Get互通
+	require.NoError(t, session.Set(ctx, sess, "name", "tom"))
+	name, err := sess.Get(ctx, "name")
+	require.NoError(t, err)
+	assert.Equal(t, "tom", name)
+
+	_, err = session.Get[cart](ctx, sess, "missing")
+	assert.Equal(t, session.ErrKeyNotFound, err)
+}
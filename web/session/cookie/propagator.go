@@ -1,6 +1,9 @@
 package cookie
 
-import "net/http"
+import (
+	"net/http"
+	"strings"
+)
 
 type PropagatorOption func(propagator *Propagator)
 
@@ -33,7 +36,7 @@ func (c *Propagator) Inject(id string, writer http.ResponseWriter) error {
 		Value: id,
 	}
 	c.cookieOpt(cookie)
-	http.SetCookie(writer, cookie)
+	c.setCookie(writer, cookie)
 	return nil
 }
 
@@ -53,6 +56,26 @@ func (c *Propagator) Remove(writer http.ResponseWriter) error {
 		MaxAge: -1,
 	}
 	c.cookieOpt(cookie)
-	http.SetCookie(writer, cookie)
+	c.setCookie(writer, cookie)
 	return nil
 }
+
+// setCookie 同一个响应里面可能会多次下发，例如登录之后又修改了session，只保留最后一个同名的cookie
+func (c *Propagator) setCookie(writer http.ResponseWriter, cookie *http.Cookie) {
+	header := writer.Header()
+	prefix := cookie.Name + "="
+	values := header.Values("Set-Cookie")
+	kept := make([]string, 0, len(values))
+	for _, val := range values {
+		if !strings.HasPrefix(val, prefix) {
+			kept = append(kept, val)
+		}
+	}
+	if len(kept) != len(values) {
+		header.Del("Set-Cookie")
+		for _, val := range kept {
+			header.Add("Set-Cookie", val)
+		}
+	}
+	http.SetCookie(writer, cookie)
+}
@@ -0,0 +1,277 @@
+package cookie
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dongma/imola/web/session"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	errInvalidToken = errors.New("cookie-session: session无效")
+	errTokenExpired = errors.New("cookie-session: session已过期")
+)
+
+// maxTokenSize 浏览器对单个cookie的限制一般是4KB
+const maxTokenSize = 4096
+
+type StoreOption func(store *Store)
+
+// StoreWithExpiration session的有效期，默认15分钟
+func StoreWithExpiration(expiration time.Duration) StoreOption {
+	return func(store *Store) {
+		store.expiration = expiration
+	}
+}
+
+// Store 把整个session加密之后保存在cookie里面，服务端不需要保存任何数据
+// 注意：cookie一旦下发就没有办法在服务端撤销，Remove只是让客户端删除cookie
+// 需要配合Propagator使用，Propagator传递的不是session id而是加密之后的token
+type Store struct {
+	keys       []sessionKey
+	expiration time.Duration
+}
+
+// sessionKey 从同一个密钥派生出加密和签名使用的两个key
+type sessionKey struct {
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+// NewStore keys是用于加密和签名的密钥，每个至少32字节
+// 新的token使用第一个密钥，解析的时候会依次尝试所有的密钥，所以轮换密钥的时候把新的密钥放在最前面即可
+func NewStore(keys [][]byte, opts ...StoreOption) (*Store, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("cookie-session: 至少需要一个密钥")
+	}
+	res := &Store{
+		keys:       make([]sessionKey, 0, len(keys)),
+		expiration: time.Minute * 15,
+	}
+	for i, key := range keys {
+		if len(key) < 32 {
+			return nil, fmt.Errorf("cookie-session: 第%d个密钥长度不足32字节", i)
+		}
+		block, err := aes.NewCipher(deriveKey(key, "encrypt"))
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		res.keys = append(res.keys, sessionKey{aead: aead, macKey: deriveKey(key, "sign")})
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res, nil
+}
+
+func deriveKey(secret []byte, usage string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("imola-cookie-session-" + usage))
+	return mac.Sum(nil)
+}
+
+// Generate 生成一个空的session
+func (s *Store) Generate(ctx context.Context, id string) (session.Session, error) {
+	return &Session{
+		store:     s,
+		id:        id,
+		data:      make(map[string]string),
+		expiresAt: time.Now().Add(s.expiration),
+		modified:  true,
+	}, nil
+}
+
+// Refresh token里面保存了过期时间，重新下发token才是刷新，由Manager通过ClientSession.Token完成
+// id是session本身的id而不是token，服务端没有保存数据，这里只检查id是否合法
+func (s *Store) Refresh(ctx context.Context, id string) error {
+	if id == "" {
+		return errInvalidToken
+	}
+	return nil
+}
+
+// Remove 服务端没有保存数据，什么也不做
+func (s *Store) Remove(ctx context.Context, id string) error {
+	return nil
+}
+
+// Get id是Propagator从请求中解析出来的token
+func (s *Store) Get(ctx context.Context, id string) (session.Session, error) {
+	p, err := s.decode(id)
+	if err != nil {
+		return nil, err
+	}
+	if p.Data == nil {
+		p.Data = make(map[string]string)
+	}
+	return &Session{
+		store:     s,
+		id:        p.ID,
+		data:      p.Data,
+		expiresAt: time.Unix(p.ExpiresAt, 0),
+	}, nil
+}
+
+// payload token中保存的内容
+type payload struct {
+	ID        string            `json:"id"`
+	Data      map[string]string `json:"data,omitempty"`
+	ExpiresAt int64             `json:"exp"`
+}
+
+// encode token的格式是base64url(nonce | AES-GCM密文 | HMAC-SHA256签名)
+func (s *Store) encode(p payload) (string, error) {
+	plain, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	key := s.keys[0]
+	nonce := make([]byte, key.aead.NonceSize(), key.aead.NonceSize()+len(plain)+key.aead.Overhead()+sha256.Size)
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	data := key.aead.Seal(nonce, nonce, plain, nil)
+	mac := hmac.New(sha256.New, key.macKey)
+	mac.Write(data)
+	token := base64.RawURLEncoding.EncodeToString(mac.Sum(data))
+	if len(token) > maxTokenSize {
+		return "", fmt.Errorf("cookie-session: session数据过大, %d字节", len(token))
+	}
+	return token, nil
+}
+
+func (s *Store) decode(token string) (payload, error) {
+	var p payload
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(data) < sha256.Size {
+		return p, errInvalidToken
+	}
+	data, sig := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	for _, key := range s.keys {
+		mac := hmac.New(sha256.New, key.macKey)
+		mac.Write(data)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			continue
+		}
+		nonceSize := key.aead.NonceSize()
+		if len(data) < nonceSize {
+			return p, errInvalidToken
+		}
+		plain, err := key.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+		if err != nil {
+			return p, errInvalidToken
+		}
+		if err = json.Unmarshal(plain, &p); err != nil {
+			return p, errInvalidToken
+		}
+		if time.Now().Unix() >= p.ExpiresAt {
+			return p, errTokenExpired
+		}
+		return p, nil
+	}
+	return p, errInvalidToken
+}
+
+// 确保Session是一个ClientSession，Manager会下发token而不是id
+var _ session.ClientSession = &Session{}
+
+type Session struct {
+	store     *Store
+	mutex     sync.RWMutex
+	id        string
+	data      map[string]string
+	expiresAt time.Time
+	modified  bool
+}
+
+func (s *Session) Get(ctx context.Context, key string) (string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	val, ok := s.data[key]
+	if !ok {
+		return "", session.ErrKeyNotFound
+	}
+	return val, nil
+}
+
+func (s *Session) Set(ctx context.Context, key string, val string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data[key] = val
+	s.modified = true
+	return nil
+}
+
+func (s *Session) Delete(ctx context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.data, key)
+	s.modified = true
+	return nil
+}
+
+func (s *Session) Keys(ctx context.Context) ([]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	keys := make([]string, 0, len(s.data))
+	for key := range s.data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *Session) Clear(ctx context.Context) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data = make(map[string]string)
+	s.modified = true
+	return nil
+}
+
+func (s *Session) ID() string {
+	return s.id
+}
+
+// Token 使用当前的数据生成token，过期时间从现在开始重新计算
+func (s *Session) Token(ctx context.Context) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.expiresAt = time.Now().Add(s.store.expiration)
+	token, err := s.store.encode(payload{
+		ID:        s.id,
+		Data:      s.data,
+		ExpiresAt: s.expiresAt.Unix(),
+	})
+	if err == nil {
+		s.modified = false
+	}
+	return token, err
+}
+
+func (s *Session) Modified() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.modified
+}
+
+// ExpiresAt token中保存的过期时间
+func (s *Session) ExpiresAt(ctx context.Context) (time.Time, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.expiresAt, nil
+}
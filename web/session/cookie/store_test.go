@@ -0,0 +1,98 @@
+package cookie
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"github.com/dongma/imola/web/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	oldKey, newKey := bytes.Repeat([]byte("o"), 32), bytes.Repeat([]byte("n"), 32)
+	oldStore, err := NewStore([][]byte{oldKey})
+	require.NoError(t, err)
+
+	sess, err := oldStore.Generate(ctx, "sess-1")
+	require.NoError(t, err)
+	require.NoError(t, sess.Set(ctx, "name", "tom"))
+	type user struct {
+		ID   int64
+		Role string
+	}
+	require.NoError(t, session.Set(ctx, sess, "user", user{ID: 12, Role: "admin"}))
+	token, err := sess.(session.ClientSession).Token(ctx)
+	require.NoError(t, err)
+
+	// 轮换密钥之后，旧的token依旧可以解析
+	store, err := NewStore([][]byte{newKey, oldKey})
+	require.NoError(t, err)
+	got, err := store.Get(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, "sess-1", got.ID())
+	name, err := got.Get(ctx, "name")
+	require.NoError(t, err)
+	assert.Equal(t, "tom", name)
+	u, err := session.Get[user](ctx, got, "user")
+	require.NoError(t, err)
+	assert.Equal(t, user{ID: 12, Role: "admin"}, u)
+	keys, err := got.Keys(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"name", "user"}, keys)
+
+	// 新的token使用新的密钥，只有旧密钥的Store无法解析
+	require.NoError(t, got.Delete(ctx, "name"))
+	newToken, err := got.(session.ClientSession).Token(ctx)
+	require.NoError(t, err)
+	_, err = oldStore.Get(ctx, newToken)
+	assert.Equal(t, errInvalidToken, err)
+	got, err = store.Get(ctx, newToken)
+	require.NoError(t, err)
+	_, err = got.Get(ctx, "name")
+	assert.Equal(t, session.ErrKeyNotFound, err)
+
+	require.NoError(t, got.Clear(ctx))
+	keys, err = got.Keys(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestStore_InvalidToken(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewStore([][]byte{bytes.Repeat([]byte("k"), 32)})
+	require.NoError(t, err)
+	sess, err := store.Generate(ctx, "sess-1")
+	require.NoError(t, err)
+	token, err := sess.(session.ClientSession).Token(ctx)
+	require.NoError(t, err)
+
+	// 篡改token
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	require.NoError(t, err)
+	data[len(data)/2] ^= 1
+	_, err = store.Get(ctx, base64.RawURLEncoding.EncodeToString(data))
+	assert.Equal(t, errInvalidToken, err)
+
+	_, err = store.Get(ctx, "not-a-token")
+	assert.Equal(t, errInvalidToken, err)
+
+	expired, err := NewStore([][]byte{bytes.Repeat([]byte("k"), 32)}, StoreWithExpiration(-time.Second))
+	require.NoError(t, err)
+	sess, err = expired.Generate(ctx, "sess-2")
+	require.NoError(t, err)
+	token, err = sess.(session.ClientSession).Token(ctx)
+	require.NoError(t, err)
+	_, err = store.Get(ctx, token)
+	assert.Equal(t, errTokenExpired, err)
+}
+
+func TestNewStore(t *testing.T) {
+	_, err := NewStore(nil)
+	assert.Error(t, err)
+	_, err = NewStore([][]byte{[]byte("short")})
+	assert.Error(t, err)
+}
@@ -0,0 +1,77 @@
+package header
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var errNoSessionID = errors.New("header-session: 请求头中没有session id")
+
+type PropagatorOption func(propagator *Propagator)
+
+// WithScheme 请求头的值带有认证方案，例如Authorization: Bearer <id>
+func WithScheme(scheme string) PropagatorOption {
+	return func(propagator *Propagator) {
+		propagator.scheme = scheme
+	}
+}
+
+// WithResponseHeader 注入session id时使用的响应头，默认和请求头相同
+func WithResponseHeader(name string) PropagatorOption {
+	return func(propagator *Propagator) {
+		propagator.respHeader = name
+	}
+}
+
+// Propagator 通过请求头传递session id，适用于移动端、API这种不方便使用cookie的客户端
+// 客户端从响应头中拿到session id，之后的请求放在请求头里面
+type Propagator struct {
+	header     string
+	scheme     string
+	respHeader string
+}
+
+func NewPropagator(header string, opts ...PropagatorOption) *Propagator {
+	res := &Propagator{
+		header:     header,
+		respHeader: header,
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+// NewBearerPropagator 从Authorization: Bearer <id>中解析session id，通过X-Session-Id响应头下发
+func NewBearerPropagator() *Propagator {
+	return NewPropagator("Authorization", WithScheme("Bearer"), WithResponseHeader("X-Session-Id"))
+}
+
+// Inject 将session id注入到http响应头里面
+func (p *Propagator) Inject(id string, writer http.ResponseWriter) error {
+	writer.Header().Set(p.respHeader, id)
+	return nil
+}
+
+// Extract 将session id从http请求头中解析出来，认证方案不区分大小写
+func (p *Propagator) Extract(req *http.Request) (string, error) {
+	val := strings.TrimSpace(req.Header.Get(p.header))
+	if p.scheme != "" {
+		scheme, id, ok := strings.Cut(val, " ")
+		if !ok || !strings.EqualFold(scheme, p.scheme) {
+			return "", errNoSessionID
+		}
+		val = strings.TrimSpace(id)
+	}
+	if val == "" {
+		return "", errNoSessionID
+	}
+	return val, nil
+}
+
+// Remove 响应头没有办法让客户端删除数据，所以返回一个空值，客户端看到空值之后删除本地保存的session id
+func (p *Propagator) Remove(writer http.ResponseWriter) error {
+	writer.Header().Set(p.respHeader, "")
+	return nil
+}
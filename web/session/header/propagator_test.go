@@ -0,0 +1,76 @@
+package header
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPropagator_Extract(t *testing.T) {
+	testCases := []struct {
+		name       string
+		propagator *Propagator
+		header     string
+		val        string
+		wantID     string
+		wantErr    error
+	}{
+		{
+			name:       "plain",
+			propagator: NewPropagator("X-Session-Id"),
+			header:     "X-Session-Id",
+			val:        "sess-1",
+			wantID:     "sess-1",
+		},
+		{
+			name:       "missing",
+			propagator: NewPropagator("X-Session-Id"),
+			wantErr:    errNoSessionID,
+		},
+		{
+			name:       "bearer",
+			propagator: NewBearerPropagator(),
+			header:     "Authorization",
+			val:        "bearer  sess-2",
+			wantID:     "sess-2",
+		},
+		{
+			name:       "other scheme",
+			propagator: NewBearerPropagator(),
+			header:     "Authorization",
+			val:        "Basic dG9tOjEyMw==",
+			wantErr:    errNoSessionID,
+		},
+		{
+			name:       "empty bearer",
+			propagator: NewBearerPropagator(),
+			header:     "Authorization",
+			val:        "Bearer ",
+			wantErr:    errNoSessionID,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.header != "" {
+				req.Header.Set(tc.header, tc.val)
+			}
+			id, err := tc.propagator.Extract(req)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantID, id)
+		})
+	}
+}
+
+func TestPropagator_InjectAndRemove(t *testing.T) {
+	p := NewBearerPropagator()
+	recorder := httptest.NewRecorder()
+	require.NoError(t, p.Inject("sess-1", recorder))
+	assert.Equal(t, "sess-1", recorder.Header().Get("X-Session-Id"))
+
+	require.NoError(t, p.Remove(recorder))
+	assert.Equal(t, []string{""}, recorder.Header().Values("X-Session-Id"))
+}
@@ -1,6 +1,7 @@
 package session
 
 import (
+	"errors"
 	"github.com/dongma/imola/web"
 )
 
@@ -39,9 +40,13 @@ func (m *Manager) InitSession(ctx *web.Context, id string) (Session, error) {
 	if err != nil {
 		return nil, err
 	}
-	if err = m.Inject(id, ctx.Resp); err != nil {
+	if err = m.inject(ctx, session); err != nil {
 		return nil, err
 	}
+	if ctx.UserValues == nil {
+		ctx.UserValues = make(map[string]any, 8)
+	}
+	ctx.UserValues[m.SessionCtxKey] = session
 	return session, nil
 }
 
@@ -57,12 +62,58 @@ func (m *Manager) RefreshSession(ctx *web.Context) (Session, error) {
 		return nil, err
 	}
 	// 重新注入到http里面
-	if err = m.Inject(session.ID(), ctx.Resp); err != nil {
+	if err = m.inject(ctx, session); err != nil {
 		return nil, err
 	}
 	return session, nil
 }
 
+// RotateSession 更换当前session的id并且重新下发，数据保持不变，Store需要实现Rotator
+func (m *Manager) RotateSession(ctx *web.Context, newID string) (Session, error) {
+	rotator, ok := m.Store.(Rotator)
+	if !ok {
+		return nil, errors.New("session: Store不支持更换session id")
+	}
+	old, err := m.GetSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	session, err := rotator.Rotate(ctx.Req.Context(), old.ID(), newID)
+	if err != nil {
+		return nil, err
+	}
+	if err = m.inject(ctx, session); err != nil {
+		return nil, err
+	}
+	ctx.UserValues[m.SessionCtxKey] = session
+	return session, nil
+}
+
+// SaveSession 数据保存在客户端的session，修改之后需要重新下发给客户端，其它的session什么也不做
+func (m *Manager) SaveSession(ctx *web.Context) error {
+	session, err := m.GetSession(ctx)
+	if err != nil {
+		return err
+	}
+	if _, ok := session.(ClientSession); !ok {
+		return nil
+	}
+	return m.inject(ctx, session)
+}
+
+// inject 下发给客户端的一般是session id，数据保存在客户端的session下发的是整个token
+func (m *Manager) inject(ctx *web.Context, session Session) error {
+	token := session.ID()
+	if cs, ok := session.(ClientSession); ok {
+		var err error
+		token, err = cs.Token(ctx.Req.Context())
+		if err != nil {
+			return err
+		}
+	}
+	return m.Inject(token, ctx.Resp)
+}
+
 // RemoveSession 删除session
 func (m *Manager) RemoveSession(ctx *web.Context) error {
 	session, err := m.GetSession(ctx)
@@ -73,5 +124,6 @@ func (m *Manager) RemoveSession(ctx *web.Context) error {
 	if err != nil {
 		return err
 	}
+	delete(ctx.UserValues, m.SessionCtxKey)
 	return m.Propagator.Remove(ctx.Resp)
 }
@@ -0,0 +1,48 @@
+package session_test
+
+import (
+	"context"
+	"github.com/dongma/imola/web"
+	"github.com/dongma/imola/web/session"
+	"github.com/dongma/imola/web/session/cookie"
+	"github.com/dongma/imola/web/session/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestManager_RotateSession(t *testing.T) {
+	store := memory.NewStore(time.Minute)
+	manager := &session.Manager{
+		Store:         store,
+		Propagator:    cookie.NewPropagator("sessid"),
+		SessionCtxKey: "_sess",
+	}
+	sess, err := store.Generate(context.Background(), "old")
+	require.NoError(t, err)
+	require.NoError(t, sess.Set(context.Background(), "name", "tom"))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "sessid", Value: "old"})
+	recorder := httptest.NewRecorder()
+	ctx := &web.Context{Req: req, Resp: recorder}
+	rotated, err := manager.RotateSession(ctx, "new")
+	require.NoError(t, err)
+	assert.Equal(t, "new", rotated.ID())
+	name, err := rotated.Get(context.Background(), "name")
+	require.NoError(t, err)
+	assert.Equal(t, "tom", name)
+
+	// 旧的id已经失效
+	_, err = store.Get(context.Background(), "old")
+	assert.Error(t, err)
+	cookies := recorder.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "new", cookies[0].Value)
+	got, err := manager.GetSession(ctx)
+	require.NoError(t, err)
+	assert.Same(t, rotated, got)
+}
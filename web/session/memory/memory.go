@@ -5,6 +5,7 @@ import (
 	"errors"
 	"github.com/dongma/imola/web/session"
 	cache "github.com/patrickmn/go-cache"
+	"sort"
 	"sync"
 	"time"
 )
@@ -29,8 +30,9 @@ func (m *Store) Generate(ctx context.Context, id string) (session.Session, error
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	session := &memorySession{
-		id:   id,
-		data: make(map[string]string),
+		store: m,
+		id:    id,
+		data:  make(map[string]string),
 	}
 	m.cache.Set(session.ID(), session, m.expiration)
 	return session, nil
@@ -55,6 +57,31 @@ func (m *Store) Remove(ctx context.Context, id string) error {
 	return nil
 }
 
+// Rotate 把数据转移到newID，oldID随之失效
+func (m *Store) Rotate(ctx context.Context, oldID string, newID string) (session.Session, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	val, expiresAt, ok := m.cache.GetWithExpiration(oldID)
+	if !ok {
+		return nil, errors.New("session not found")
+	}
+	old := val.(*memorySession)
+	old.mutex.RLock()
+	data := make(map[string]string, len(old.data))
+	for k, v := range old.data {
+		data[k] = v
+	}
+	old.mutex.RUnlock()
+	sess := &memorySession{
+		store: m,
+		id:    newID,
+		data:  data,
+	}
+	m.cache.Set(newID, sess, time.Until(expiresAt))
+	m.cache.Delete(oldID)
+	return sess, nil
+}
+
 // Get 获取session信息
 func (m *Store) Get(ctx context.Context, id string) (session.Session, error) {
 	m.mutex.Lock()
@@ -67,6 +94,7 @@ func (m *Store) Get(ctx context.Context, id string) (session.Session, error) {
 }
 
 type memorySession struct {
+	store *Store
 	mutex sync.RWMutex
 	id    string
 	data  map[string]string
@@ -77,7 +105,7 @@ func (m *memorySession) Get(ctx context.Context, key string) (string, error) {
 	defer m.mutex.RUnlock()
 	val, ok := m.data[key]
 	if !ok {
-		return "", errors.New("找不到这个key")
+		return "", session.ErrKeyNotFound
 	}
 	return val, nil
 }
@@ -89,6 +117,40 @@ func (m *memorySession) Set(ctx context.Context, key string, val string) error {
 	return nil
 }
 
+func (m *memorySession) Delete(ctx context.Context, key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.data, key)
+	return nil
+}
+
+func (m *memorySession) Keys(ctx context.Context) ([]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	keys := make([]string, 0, len(m.data))
+	for key := range m.data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *memorySession) Clear(ctx context.Context) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.data = make(map[string]string)
+	return nil
+}
+
+// ExpiresAt 过期时间由go-cache管理
+func (m *memorySession) ExpiresAt(ctx context.Context) (time.Time, error) {
+	_, expiresAt, ok := m.store.cache.GetWithExpiration(m.id)
+	if !ok {
+		return time.Time{}, errors.New("session not found")
+	}
+	return expiresAt, nil
+}
+
 func (m *memorySession) ID() string {
 	return m.id
 }
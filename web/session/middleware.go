@@ -0,0 +1,130 @@
+package session
+
+import (
+	"github.com/dongma/imola/web"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// MiddlewareBuilder 每个请求只加载一次session，放在ctx.UserValues中，handler通过Manager.GetSession拿到
+// 同时实现了滑动过期：session的剩余时间不足的时候才刷新，避免每个请求都写一次存储
+type MiddlewareBuilder struct {
+	manager *Manager
+	// lifetime session的有效期，需要和Store的过期时间一致
+	lifetime time.Duration
+	// 已经过去的时间超过lifetime的refreshAfter之后才刷新
+	refreshAfter float64
+}
+
+func NewMiddlewareBuilder(manager *Manager, lifetime time.Duration) *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		manager:      manager,
+		lifetime:     lifetime,
+		refreshAfter: 0.5,
+	}
+}
+
+// RefreshAfter fraction在0到1之间，0表示每个请求都刷新
+func (m *MiddlewareBuilder) RefreshAfter(fraction float64) *MiddlewareBuilder {
+	m.refreshAfter = fraction
+	return m
+}
+
+func (m MiddlewareBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			// 没有session的请求照常处理，是否需要登录由Guard决定
+			if sess, err := m.manager.GetSession(ctx); err == nil && m.needRefresh(ctx, sess) {
+				// 数据保存在客户端的session，重新下发token才会更新过期时间
+				if _, ok := sess.(ClientSession); ok {
+					_ = m.manager.SaveSession(ctx)
+				} else {
+					_, _ = m.manager.RefreshSession(ctx)
+				}
+			}
+			next(ctx)
+			// 数据保存在客户端的session，修改之后需要重新下发，Propagator会替换掉之前下发的同名cookie
+			// 流式响应已经把响应头写出去了，这里就来不及了
+			if val, ok := ctx.UserValues[m.manager.SessionCtxKey]; ok {
+				if cs, ok := val.(ClientSession); ok && cs.Modified() {
+					_ = m.manager.SaveSession(ctx)
+				}
+			}
+		}
+	}
+}
+
+// needRefresh 不知道过期时间的session每次都刷新
+func (m MiddlewareBuilder) needRefresh(ctx *web.Context, sess Session) bool {
+	exp, ok := sess.(Expirable)
+	if !ok || m.lifetime <= 0 {
+		return true
+	}
+	expiresAt, err := exp.ExpiresAt(ctx.Req.Context())
+	if err != nil {
+		return false
+	}
+	elapsed := m.lifetime - time.Until(expiresAt)
+	return float64(elapsed) >= float64(m.lifetime)*m.refreshAfter
+}
+
+// GuardBuilder 需要登录的路由使用，例如挂在RouterGroup上
+// 没有登录时返回401，设置了loginPath时GET、HEAD请求重定向到登录页
+type GuardBuilder struct {
+	manager   *Manager
+	loginPath string
+	check     func(ctx *web.Context, sess Session) bool
+	allowIf   func(ctx *web.Context) bool
+}
+
+func NewGuardBuilder(manager *Manager) *GuardBuilder {
+	return &GuardBuilder{
+		manager: manager,
+		check: func(ctx *web.Context, sess Session) bool {
+			return true
+		},
+	}
+}
+
+// LoginPath 重定向到登录页，原始的地址放在redirect查询参数里面
+func (g *GuardBuilder) LoginPath(path string) *GuardBuilder {
+	g.loginPath = path
+	return g
+}
+
+// Check 进一步判断session是否已经登录，例如session中是否有uid，默认有session就认为已经登录
+func (g *GuardBuilder) Check(fn func(ctx *web.Context, sess Session) bool) *GuardBuilder {
+	g.check = fn
+	return g
+}
+
+// AllowIf 请求已经通过其它方式认证时不再要求session，例如带了有效JWT的API请求
+func (g *GuardBuilder) AllowIf(fn func(ctx *web.Context) bool) *GuardBuilder {
+	g.allowIf = fn
+	return g
+}
+
+func (g GuardBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			if g.allowIf != nil && g.allowIf(ctx) {
+				next(ctx)
+				return
+			}
+			sess, err := g.manager.GetSession(ctx)
+			if err == nil && g.check(ctx, sess) {
+				next(ctx)
+				return
+			}
+			method := ctx.Req.Method
+			if g.loginPath != "" && (method == http.MethodGet || method == http.MethodHead) {
+				ctx.Resp.Header().Set("Location", g.loginPath+"?redirect="+url.QueryEscape(ctx.Req.URL.RequestURI()))
+				ctx.RespStatusCode = http.StatusFound
+				return
+			}
+			ctx.RespStatusCode = http.StatusUnauthorized
+			ctx.RespData = []byte("unauthorized")
+		}
+	}
+}
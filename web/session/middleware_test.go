@@ -0,0 +1,186 @@
+package session_test
+
+import (
+	"bytes"
+	"github.com/dongma/imola/web"
+	"github.com/dongma/imola/web/session"
+	"github.com/dongma/imola/web/session/cookie"
+	"github.com/dongma/imola/web/session/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareBuilder(t *testing.T) {
+	store := memory.NewStore(time.Minute)
+	manager := &session.Manager{
+		Store:         store,
+		Propagator:    cookie.NewPropagator("sessid"),
+		SessionCtxKey: "_sess",
+	}
+	server := web.NewHTTPServer(web.ServerWithMiddleware(
+		session.NewMiddlewareBuilder(manager, time.Minute).RefreshAfter(0.5).Build()))
+	server.GET("/login", func(ctx *web.Context) {
+		sess, err := manager.InitSession(ctx, "sess-1")
+		require.NoError(t, err)
+		require.NoError(t, sess.Set(ctx.Req.Context(), "uid", "12"))
+	})
+	admin := server.Group("/admin", session.NewGuardBuilder(manager).LoginPath("/login").
+		Check(func(ctx *web.Context, sess session.Session) bool {
+			uid, err := sess.Get(ctx.Req.Context(), "uid")
+			return err == nil && uid != ""
+		}).Build())
+	admin.GET("/profile", func(ctx *web.Context) {
+		sess, err := manager.GetSession(ctx)
+		require.NoError(t, err)
+		uid, _ := sess.Get(ctx.Req.Context(), "uid")
+		ctx.RespData = []byte(uid)
+	})
+	admin.POST("/profile", func(ctx *web.Context) {})
+
+	send := func(method string, path string, sessID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if sessID != "" {
+			req.AddCookie(&http.Cookie{Name: "sessid", Value: sessID})
+		}
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// 没有登录
+	resp := send(http.MethodGet, "/admin/profile?tab=1", "")
+	assert.Equal(t, http.StatusFound, resp.Code)
+	assert.Equal(t, "/login?redirect=%2Fadmin%2Fprofile%3Ftab%3D1", resp.Header().Get("Location"))
+	resp = send(http.MethodPost, "/admin/profile", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	resp = send(http.MethodGet, "/login", "")
+	require.Len(t, resp.Result().Cookies(), 1)
+
+	// 刚刚登录，不需要刷新
+	resp = send(http.MethodGet, "/admin/profile", "sess-1")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "12", resp.Body.String())
+	assert.Empty(t, resp.Result().Cookies())
+
+}
+
+func TestMiddlewareBuilder_Refresh(t *testing.T) {
+	// Store的过期时间比lifetime短，相当于已经过去了大半的时间
+	manager := &session.Manager{
+		Store:         memory.NewStore(20 * time.Second),
+		Propagator:    cookie.NewPropagator("sessid"),
+		SessionCtxKey: "_sess",
+	}
+	server := web.NewHTTPServer(web.ServerWithMiddleware(
+		session.NewMiddlewareBuilder(manager, time.Minute).Build()))
+	server.GET("/login", func(ctx *web.Context) {
+		_, err := manager.InitSession(ctx, "sess-1")
+		require.NoError(t, err)
+	})
+	server.GET("/profile", func(ctx *web.Context) {})
+
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/login", nil))
+	req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+	req.AddCookie(&http.Cookie{Name: "sessid", Value: "sess-1"})
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	cookies := recorder.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "sess-1", cookies[0].Value)
+}
+
+func TestMiddlewareBuilder_ClientSession(t *testing.T) {
+	store, err := cookie.NewStore([][]byte{bytes.Repeat([]byte("k"), 32)}, cookie.StoreWithExpiration(time.Minute))
+	require.NoError(t, err)
+	manager := &session.Manager{
+		Store:         store,
+		Propagator:    cookie.NewPropagator("sess"),
+		SessionCtxKey: "_sess",
+	}
+	server := web.NewHTTPServer(web.ServerWithMiddleware(
+		session.NewMiddlewareBuilder(manager, time.Minute).Build()))
+	server.GET("/login", func(ctx *web.Context) {
+		sess, err := manager.InitSession(ctx, "sess-1")
+		require.NoError(t, err)
+		// InitSession之后修改的数据由middleware重新下发
+		require.NoError(t, sess.Set(ctx.Req.Context(), "uid", "12"))
+	})
+	server.GET("/uid", func(ctx *web.Context) {
+		sess, err := manager.GetSession(ctx)
+		if err != nil {
+			ctx.RespStatusCode = http.StatusUnauthorized
+			return
+		}
+		uid, _ := sess.Get(ctx.Req.Context(), "uid")
+		ctx.RespData = []byte(uid)
+	})
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/login", nil))
+	cookies := recorder.Result().Cookies()
+	// InitSession和修改之后的重新下发只会留下一个cookie
+	require.Len(t, cookies, 1)
+	token := cookies[0].Value
+
+	req := httptest.NewRequest(http.MethodGet, "/uid", nil)
+	req.AddCookie(&http.Cookie{Name: "sess", Value: token})
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, "12", recorder.Body.String())
+	// 没有修改，也不需要刷新
+	assert.Empty(t, recorder.Result().Cookies())
+}
+
+func TestMiddlewareBuilder_RefreshCookieSession(t *testing.T) {
+	// token的有效期比lifetime短，相当于已经过去了大半的时间
+	store, err := cookie.NewStore([][]byte{bytes.Repeat([]byte("k"), 32)}, cookie.StoreWithExpiration(20*time.Second))
+	require.NoError(t, err)
+	manager := &session.Manager{
+		Store:         store,
+		Propagator:    cookie.NewPropagator("sess"),
+		SessionCtxKey: "_sess",
+	}
+	server := web.NewHTTPServer(web.ServerWithMiddleware(
+		session.NewMiddlewareBuilder(manager, time.Minute).Build()))
+	server.GET("/login", func(ctx *web.Context) {
+		sess, err := manager.InitSession(ctx, "sess-1")
+		require.NoError(t, err)
+		require.NoError(t, sess.Set(ctx.Req.Context(), "uid", "12"))
+	})
+	server.GET("/uid", func(ctx *web.Context) {
+		sess, err := manager.GetSession(ctx)
+		if err != nil {
+			ctx.RespStatusCode = http.StatusUnauthorized
+			return
+		}
+		uid, _ := sess.Get(ctx.Req.Context(), "uid")
+		ctx.RespData = []byte(uid)
+	})
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/login", nil))
+	cookies := recorder.Result().Cookies()
+	require.Len(t, cookies, 1)
+	token := cookies[0].Value
+
+	req := httptest.NewRequest(http.MethodGet, "/uid", nil)
+	req.AddCookie(&http.Cookie{Name: "sess", Value: token})
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, "12", recorder.Body.String())
+	cookies = recorder.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.NotEqual(t, token, cookies[0].Value)
+
+	// 新的token依旧可以使用
+	req = httptest.NewRequest(http.MethodGet, "/uid", nil)
+	req.AddCookie(&http.Cookie{Name: "sess", Value: cookies[0].Value})
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, "12", recorder.Body.String())
+}
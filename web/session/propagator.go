@@ -0,0 +1,52 @@
+package session
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ChainPropagator 组合多个Propagator，例如同时支持浏览器的cookie和移动端的请求头
+// Extract按照顺序尝试，使用第一个成功的结果；Inject、Remove对所有的Propagator生效
+type ChainPropagator struct {
+	propagators []Propagator
+}
+
+func NewChainPropagator(propagators ...Propagator) *ChainPropagator {
+	return &ChainPropagator{propagators: propagators}
+}
+
+// Inject 将session id注入到所有的Propagator里面，客户端使用自己认识的那一个
+func (c *ChainPropagator) Inject(id string, writer http.ResponseWriter) error {
+	for _, p := range c.propagators {
+		if err := p.Inject(id, writer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Extract 按照顺序解析session id，全部失败时返回所有的error
+func (c *ChainPropagator) Extract(req *http.Request) (string, error) {
+	errs := make([]error, 0, len(c.propagators))
+	for _, p := range c.propagators {
+		id, err := p.Extract(req)
+		if err == nil {
+			return id, nil
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return "", errors.New("session: 没有可用的Propagator")
+	}
+	return "", errors.Join(errs...)
+}
+
+// Remove 从所有的Propagator中删除session id
+func (c *ChainPropagator) Remove(writer http.ResponseWriter) error {
+	for _, p := range c.propagators {
+		if err := p.Remove(writer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package session_test
+
+import (
+	"github.com/dongma/imola/web/session"
+	"github.com/dongma/imola/web/session/cookie"
+	"github.com/dongma/imola/web/session/header"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChainPropagator(t *testing.T) {
+	p := session.NewChainPropagator(cookie.NewPropagator("sessid"), header.NewBearerPropagator())
+
+	testCases := []struct {
+		name    string
+		req     func() *http.Request
+		wantID  string
+		wantErr bool
+	}{
+		{
+			name: "cookie",
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.AddCookie(&http.Cookie{Name: "sessid", Value: "from-cookie"})
+				req.Header.Set("Authorization", "Bearer from-header")
+				return req
+			},
+			wantID: "from-cookie",
+		},
+		{
+			name: "header",
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.Header.Set("Authorization", "Bearer from-header")
+				return req
+			},
+			wantID: "from-header",
+		},
+		{
+			name: "none",
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/", nil)
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			id, err := p.Extract(tc.req())
+			assert.Equal(t, tc.wantErr, err != nil)
+			assert.Equal(t, tc.wantID, id)
+		})
+	}
+
+	recorder := httptest.NewRecorder()
+	require.NoError(t, p.Inject("sess-1", recorder))
+	assert.Equal(t, "sess-1", recorder.Header().Get("X-Session-Id"))
+	cookies := recorder.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "sess-1", cookies[0].Value)
+}
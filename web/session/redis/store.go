@@ -6,17 +6,51 @@ import (
 	"fmt"
 	"github.com/dongma/imola/web/session"
 	"github.com/redis/go-redis/v9"
+	"sort"
 	"time"
 )
 
-var errSessionNotExist = errors.New("redis-session: session不存在")
+var (
+	errSessionNotExist = errors.New("redis-session: session不存在")
+	errSessionExist    = errors.New("redis-session: 新的session id已经存在")
+	errReservedKey     = errors.New("redis-session: _sess_id和_uid是内部使用的key")
+)
+
+const (
+	// sessIDField 每个session的hash中都会保存session id，保证hash在没有数据的时候也存在
+	sessIDField = "_sess_id"
+	// uidField 绑定了用户的session，保存用户id，用于维护用户的session索引
+	uidField = "_uid"
+)
 
 type StoreOption func(store *Store)
 
+// StoreWithPrefix key的前缀，默认是session
+func StoreWithPrefix(prefix string) StoreOption {
+	return func(store *Store) {
+		store.prefix = prefix
+	}
+}
+
+// StoreWithExpiration session的有效期，默认15分钟
+func StoreWithExpiration(expiration time.Duration) StoreOption {
+	return func(store *Store) {
+		store.expiration = expiration
+	}
+}
+
+// StoreWithMaxSessionsPerUser 每个用户最多同时存在的session数量，超过时淘汰最早绑定的session，0表示不限制
+func StoreWithMaxSessionsPerUser(max int) StoreOption {
+	return func(store *Store) {
+		store.maxSessions = max
+	}
+}
+
 type Store struct {
-	prefix     string
-	client     redis.Cmdable
-	expiration time.Duration
+	prefix      string
+	client      redis.Cmdable
+	expiration  time.Duration
+	maxSessions int
 }
 
 // NewStore 创建一个Store的实例，可以考虑使用Option设计模式，允许用户控制过期检查的问题
@@ -32,6 +66,11 @@ func NewStore(client redis.Cmdable, opts ...StoreOption) *Store {
 	return redisStore
 }
 
+// sessKeyPrefix session的key保持 prefix_id 的格式，用户索引使用 prefix:user: 前缀，两者不会冲突
+func (s *Store) sessKeyPrefix() string {
+	return s.prefix + "_"
+}
+
 func (s *Store) key(id string) string {
 	return fmt.Sprintf("%s_%s", s.prefix, id)
 }
@@ -43,7 +82,7 @@ redis.call("hset", KEYS[1], ARGV[1], ARGV[2])
 return redis.call("pexpire", KEYS[1], ARGV[3])
 `
 	key := s.key(id)
-	_, err := s.client.Eval(ctx, lua, []string{key}, "_sess_id", id, s.expiration.Milliseconds()).Result()
+	_, err := s.client.Eval(ctx, lua, []string{key}, sessIDField, id, s.expiration.Milliseconds()).Result()
 	if err != nil {
 		return nil, err
 	}
@@ -54,34 +93,93 @@ return redis.call("pexpire", KEYS[1], ARGV[3])
 	}, nil
 }
 
-// Refresh 刷新同一个session id，使session不失效
+// Refresh 刷新同一个session id，使session不失效，绑定了用户时同时刷新用户的session索引
 func (s *Store) Refresh(ctx context.Context, id string) error {
-	key := s.key(id)
-	affected, err := s.client.Expire(ctx, key, s.expiration).Result()
+	const lua = `
+if redis.call("pexpire", KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+local uid = redis.call("hget", KEYS[1], ARGV[2])
+if uid then
+	redis.call("pexpire", ARGV[3] .. uid, ARGV[1])
+end
+return 1
+`
+	res, err := s.client.Eval(ctx, lua, []string{s.key(id)},
+		s.expiration.Milliseconds(), uidField, s.userKeyPrefix()).Int()
 	if err != nil {
 		return err
 	}
-	if !affected {
+	if res == 0 {
 		return errSessionNotExist
 	}
 	return nil
 }
 
+// Remove 删除session，同时从用户的session索引中删除
 func (s *Store) Remove(ctx context.Context, id string) error {
-	_, err := s.client.Del(ctx, s.key(id)).Result()
-	return err
+	const lua = `
+local uid = redis.call("hget", KEYS[1], ARGV[1])
+redis.call("del", KEYS[1])
+if uid then
+	redis.call("zrem", ARGV[2] .. uid, ARGV[3])
+end
+return 1
+`
+	return s.client.Eval(ctx, lua, []string{s.key(id)}, uidField, s.userKeyPrefix(), id).Err()
+}
+
+// Rotate 使用renamenx原子地把数据转移到newID，过期时间不变，用户的session索引中同样替换为newID
+// newID已经存在时返回错误，不会覆盖别人的session
+func (s *Store) Rotate(ctx context.Context, oldID string, newID string) (session.Session, error) {
+	const lua = `
+if redis.call("exists", KEYS[1]) == 0 then
+	return 0
+end
+if redis.call("renamenx", KEYS[1], KEYS[2]) == 0 then
+	return -1
+end
+redis.call("hset", KEYS[2], ARGV[1], ARGV[3])
+local uid = redis.call("hget", KEYS[2], ARGV[2])
+if uid then
+	local userKey = ARGV[4] .. uid
+	local score = redis.call("zscore", userKey, ARGV[5])
+	redis.call("zrem", userKey, ARGV[5])
+	if score then
+		redis.call("zadd", userKey, score, ARGV[3])
+	end
+end
+return 1
+`
+	key := s.key(newID)
+	res, err := s.client.Eval(ctx, lua, []string{s.key(oldID), key},
+		sessIDField, uidField, newID, s.userKeyPrefix(), oldID).Int()
+	if err != nil {
+		return nil, err
+	}
+	if res == 0 {
+		return nil, errSessionNotExist
+	}
+	if res < 0 {
+		return nil, errSessionExist
+	}
+	return &Session{
+		key:    key,
+		id:     newID,
+		client: s.client,
+	}, nil
 }
 
 // Get 获取session信息
 func (s *Store) Get(ctx context.Context, id string) (session.Session, error) {
 	key := s.key(id)
 	// 这里不需要考虑并发的问题，因为在你检测的当下，没有就是没有
-	i, err := s.client.Exists(ctx, id).Result()
+	i, err := s.client.Exists(ctx, key).Result()
 	if err != nil {
 		return nil, err
 	}
-	if i < 0 {
-		return nil, errors.New("redis-session: session不存在")
+	if i == 0 {
+		return nil, errSessionNotExist
 	}
 	return &Session{
 		id:     id,
@@ -97,10 +195,21 @@ type Session struct {
 }
 
 func (s *Session) Get(ctx context.Context, key string) (string, error) {
-	return s.client.HGet(ctx, s.key, key).Result()
+	if isReservedKey(key) {
+		return "", errReservedKey
+	}
+	val, err := s.client.HGet(ctx, s.key, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", session.ErrKeyNotFound
+	}
+	return val, err
 }
 
+// Set 不允许覆盖_sess_id和_uid，否则用户的session索引就对不上了
 func (s *Session) Set(ctx context.Context, key string, val string) error {
+	if isReservedKey(key) {
+		return errReservedKey
+	}
 	const lua = `
 if redis.call("exists", KEYS[1])
 then
@@ -119,6 +228,59 @@ end
 	return nil
 }
 
+func (s *Session) Delete(ctx context.Context, key string) error {
+	if isReservedKey(key) {
+		return errReservedKey
+	}
+	return s.client.HDel(ctx, s.key, key).Err()
+}
+
+// Keys 不包含内部使用的_sess_id和_uid
+func (s *Session) Keys(ctx context.Context) ([]string, error) {
+	keys, err := s.client.HKeys(ctx, s.key).Result()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !isReservedKey(key) {
+			res = append(res, key)
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// Clear 只保留内部使用的_sess_id和_uid，过期时间不变
+func (s *Session) Clear(ctx context.Context) error {
+	const lua = `
+local keys = redis.call("hkeys", KEYS[1])
+for _, k in ipairs(keys) do
+	if k ~= ARGV[1] and k ~= ARGV[2] then
+		redis.call("hdel", KEYS[1], k)
+	end
+end
+return #keys
+`
+	return s.client.Eval(ctx, lua, []string{s.key}, sessIDField, uidField).Err()
+}
+
+// ExpiresAt 根据key剩余的过期时间计算
+func (s *Session) ExpiresAt(ctx context.Context) (time.Time, error) {
+	ttl, err := s.client.PTTL(ctx, s.key).Result()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if ttl < 0 {
+		return time.Time{}, errSessionNotExist
+	}
+	return time.Now().Add(ttl), nil
+}
+
 func (s *Session) ID() string {
 	return s.id
 }
+
+func isReservedKey(key string) bool {
+	return key == sessIDField || key == uidField
+}
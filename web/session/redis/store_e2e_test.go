@@ -0,0 +1,59 @@
+//go:build e2e
+
+package redis
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestStore_e2e_UserSessions(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{
+		Addr: "127.0.0.1:6379",
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	store := NewStore(rdb, StoreWithPrefix("e2e_session"), StoreWithMaxSessionsPerUser(2))
+	_, _ = store.RevokeUser(ctx, "12")
+
+	for _, id := range []string{"s1", "s2", "s3"} {
+		sess, err := store.Generate(ctx, id)
+		require.NoError(t, err)
+		require.NoError(t, sess.Set(ctx, "name", "tom"))
+		_, err = store.BindUser(ctx, id, "12")
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond * 2)
+	}
+	// s1是最早的，被淘汰了
+	ids, err := store.UserSessions(ctx, "12")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"s2", "s3"}, ids)
+	_, err = store.Get(ctx, "s1")
+	assert.Equal(t, errSessionNotExist, err)
+
+	// 新的id已经存在时不能覆盖
+	_, err = store.Rotate(ctx, "s3", "s2")
+	assert.Equal(t, errSessionExist, err)
+
+	// 更换session id，数据保持不变
+	sess, err := store.Rotate(ctx, "s3", "s4")
+	require.NoError(t, err)
+	name, err := sess.Get(ctx, "name")
+	require.NoError(t, err)
+	assert.Equal(t, "tom", name)
+	_, err = store.Get(ctx, "s3")
+	assert.Equal(t, errSessionNotExist, err)
+	ids, err = store.UserSessions(ctx, "12")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"s2", "s4"}, ids)
+
+	cnt, err := store.RevokeUser(ctx, "12")
+	require.NoError(t, err)
+	assert.Equal(t, 2, cnt)
+	_, err = store.Get(ctx, "s4")
+	assert.Equal(t, errSessionNotExist, err)
+}
@@ -0,0 +1,141 @@
+package redis
+
+import (
+	"context"
+	"github.com/dongma/imola/cache/mocks"
+	"github.com/golang/mock/gomock"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestStore_Rotate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		mock    func(ctrl *gomock.Controller) redis.Cmdable
+		wantErr error
+	}{
+		{
+			name: "rotated",
+			mock: func(ctrl *gomock.Controller) redis.Cmdable {
+				cmd := mocks.NewMockCmdable(ctrl)
+				res := redis.NewCmd(context.Background())
+				res.SetVal(int64(1))
+				cmd.EXPECT().Eval(gomock.Any(), gomock.Any(),
+					[]string{"session_old", "session_new"},
+					sessIDField, uidField, "new", "session:user:", "old").Return(res)
+				return cmd
+			},
+		},
+		{
+			name: "not exist",
+			mock: func(ctrl *gomock.Controller) redis.Cmdable {
+				cmd := mocks.NewMockCmdable(ctrl)
+				res := redis.NewCmd(context.Background())
+				res.SetVal(int64(0))
+				cmd.EXPECT().Eval(gomock.Any(), gomock.Any(), gomock.Any(),
+					gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(res)
+				return cmd
+			},
+			wantErr: errSessionNotExist,
+		},
+		{
+			name: "new id exist",
+			mock: func(ctrl *gomock.Controller) redis.Cmdable {
+				cmd := mocks.NewMockCmdable(ctrl)
+				res := redis.NewCmd(context.Background())
+				res.SetVal(int64(-1))
+				cmd.EXPECT().Eval(gomock.Any(), gomock.Any(), gomock.Any(),
+					gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(res)
+				return cmd
+			},
+			wantErr: errSessionExist,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			store := NewStore(tc.mock(ctrl))
+			sess, err := store.Rotate(context.Background(), "old", "new")
+			assert.Equal(t, tc.wantErr, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, "new", sess.ID())
+		})
+	}
+}
+
+func TestStore_BindUser(t *testing.T) {
+	testCases := []struct {
+		name        string
+		mock        func(ctrl *gomock.Controller) redis.Cmdable
+		wantEvicted []string
+		wantErr     error
+	}{
+		{
+			name: "evicted",
+			mock: func(ctrl *gomock.Controller) redis.Cmdable {
+				cmd := mocks.NewMockCmdable(ctrl)
+				res := redis.NewCmd(context.Background())
+				res.SetVal([]any{"s1"})
+				cmd.EXPECT().Eval(gomock.Any(), gomock.Any(),
+					[]string{"session_s3", "session:user:12"},
+					uidField, "12", "s3", gomock.Any(), int64(60000), "session_", 2).Return(res)
+				return cmd
+			},
+			wantEvicted: []string{"s1"},
+		},
+		{
+			name: "session not exist",
+			mock: func(ctrl *gomock.Controller) redis.Cmdable {
+				cmd := mocks.NewMockCmdable(ctrl)
+				res := redis.NewCmd(context.Background())
+				res.SetErr(redis.Nil)
+				cmd.EXPECT().Eval(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(),
+					gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(res)
+				return cmd
+			},
+			wantErr: errSessionNotExist,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			store := NewStore(tc.mock(ctrl), StoreWithExpiration(time.Minute), StoreWithMaxSessionsPerUser(2))
+			evicted, err := store.BindUser(context.Background(), "s3", "12")
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantEvicted, evicted)
+		})
+	}
+}
+
+func TestStore_Get(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cmd := mocks.NewMockCmdable(ctrl)
+	res := redis.NewIntCmd(context.Background())
+	res.SetVal(0)
+	cmd.EXPECT().Exists(gomock.Any(), "session_revoked").Return(res)
+	_, err := NewStore(cmd).Get(context.Background(), "revoked")
+	require.Equal(t, errSessionNotExist, err)
+}
+
+func TestSession_ReservedKey(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	// 内部使用的key不会发送到redis
+	sess := &Session{key: "session_s1", id: "s1", client: mocks.NewMockCmdable(ctrl)}
+	for _, key := range []string{sessIDField, uidField} {
+		assert.Equal(t, errReservedKey, sess.Set(context.Background(), key, "12"))
+		_, err := sess.Get(context.Background(), key)
+		assert.Equal(t, errReservedKey, err)
+		assert.Equal(t, errReservedKey, sess.Delete(context.Background(), key))
+	}
+}
@@ -0,0 +1,90 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// 用户的session索引是一个zset，member是session id，score是绑定的时间
+// 注意：下面的lua脚本会根据session id拼接出session的key，在redis cluster下需要使用hash tag保证在同一个slot
+
+func (s *Store) userKeyPrefix() string {
+	return s.prefix + ":user:"
+}
+
+func (s *Store) userKey(uid string) string {
+	return s.userKeyPrefix() + uid
+}
+
+// BindUser 把session绑定到用户，登录成功之后调用。超过最大session数量时淘汰最早绑定的session，返回被淘汰的session id
+func (s *Store) BindUser(ctx context.Context, id string, uid string) ([]string, error) {
+	const lua = `
+if redis.call("exists", KEYS[1]) == 0 then
+	return false
+end
+-- 先清理已经过期的session
+local ids = redis.call("zrange", KEYS[2], 0, -1)
+for _, sid in ipairs(ids) do
+	if redis.call("exists", ARGV[6] .. sid) == 0 then
+		redis.call("zrem", KEYS[2], sid)
+	end
+end
+redis.call("hset", KEYS[1], ARGV[1], ARGV[2])
+redis.call("zadd", KEYS[2], ARGV[4], ARGV[3])
+redis.call("pexpire", KEYS[2], ARGV[5])
+
+local evicted = {}
+local max = tonumber(ARGV[7])
+if max > 0 then
+	local cnt = redis.call("zcard", KEYS[2])
+	if cnt > max then
+		local oldest = redis.call("zrange", KEYS[2], 0, cnt - max - 1)
+		for _, sid in ipairs(oldest) do
+			redis.call("del", ARGV[6] .. sid)
+			redis.call("zrem", KEYS[2], sid)
+			table.insert(evicted, sid)
+		end
+	end
+end
+return evicted
+`
+	res, err := s.client.Eval(ctx, lua, []string{s.key(id), s.userKey(uid)},
+		uidField, uid, id, time.Now().UnixMilli(), s.expiration.Milliseconds(),
+		s.sessKeyPrefix(), s.maxSessions).StringSlice()
+	if errors.Is(err, redis.Nil) {
+		return nil, errSessionNotExist
+	}
+	return res, err
+}
+
+// UserSessions 用户所有有效的session id，按照绑定的时间从早到晚排列
+func (s *Store) UserSessions(ctx context.Context, uid string) ([]string, error) {
+	const lua = `
+local res = {}
+local ids = redis.call("zrange", KEYS[1], 0, -1)
+for _, sid in ipairs(ids) do
+	if redis.call("exists", ARGV[1] .. sid) == 1 then
+		table.insert(res, sid)
+	else
+		redis.call("zrem", KEYS[1], sid)
+	end
+end
+return res
+`
+	return s.client.Eval(ctx, lua, []string{s.userKey(uid)}, s.sessKeyPrefix()).StringSlice()
+}
+
+// RevokeUser 删除用户所有的session，也就是"退出所有设备"，返回删除的数量
+func (s *Store) RevokeUser(ctx context.Context, uid string) (int, error) {
+	const lua = `
+local ids = redis.call("zrange", KEYS[1], 0, -1)
+for _, sid in ipairs(ids) do
+	redis.call("del", ARGV[1] .. sid)
+end
+redis.call("del", KEYS[1])
+return #ids
+`
+	return s.client.Eval(ctx, lua, []string{s.userKey(uid)}, s.sessKeyPrefix()).Int()
+}
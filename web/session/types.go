@@ -2,16 +2,47 @@ package session
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"time"
 )
 
-// Session 基本操作，获取、设置session信息
+// ErrKeyNotFound session中没有这个key
+var ErrKeyNotFound = errors.New("session: 找不到这个key")
+
+// Session 基本操作，获取、设置session信息，需要保存结构体等类型时使用Get[T]和Set[T]
 type Session interface {
+	// Get key不存在时返回ErrKeyNotFound
 	Get(ctx context.Context, key string) (string, error)
 	Set(ctx context.Context, key string, val string) error
+	Delete(ctx context.Context, key string) error
+	// Keys 返回所有的key，按照字典序排列
+	Keys(ctx context.Context) ([]string, error)
+	// Clear 删除所有的数据，session本身依旧有效
+	Clear(ctx context.Context) error
 	ID() string
 }
 
+// ClientSession 数据保存在客户端的session，例如cookie.Store
+// 下发给客户端的是整个session编码之后的token，而不是ID，所以数据变化之后需要重新下发
+type ClientSession interface {
+	Session
+	Token(ctx context.Context) (string, error)
+	// Modified 数据是否被修改过，修改过才需要重新下发
+	Modified() bool
+}
+
+// Rotator 支持更换session id的Store，登录、提权之后更换session id可以防止会话固定攻击
+type Rotator interface {
+	// Rotate 把oldID的数据原子地转移到newID，之后oldID失效
+	Rotate(ctx context.Context, oldID string, newID string) (Session, error)
+}
+
+// Expirable 能够知道自己什么时候过期的session，Middleware据此实现滑动过期
+type Expirable interface {
+	ExpiresAt(ctx context.Context) (time.Time, error)
+}
+
 // Store store管理session,对session提供持久化操作
 type Store interface {
 	// Generate 生成一个session
@@ -0,0 +1,186 @@
+package web
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errFlushNotSupported = errors.New("web: ResponseWriter不支持Flush，无法进行流式响应")
+	errSSEClosed         = errors.New("web: SSE连接已关闭")
+	errSSEInvalidField   = errors.New("web: SSE的event和id不能包含换行符")
+)
+
+// startStream 进入流式响应模式，直接把响应头写出去，之后的数据绕过RespData直接写到Resp里面
+// RespStatusCode依旧会被设置，access log、prometheus这些middleware能正常拿到响应码
+func (c *Context) startStream() (http.Flusher, error) {
+	flusher, ok := c.Resp.(http.Flusher)
+	if !ok {
+		return nil, errFlushNotSupported
+	}
+	if c.streaming {
+		return flusher, nil
+	}
+	if c.RespStatusCode == 0 {
+		c.RespStatusCode = http.StatusOK
+	}
+	c.streaming = true
+	c.Resp.WriteHeader(c.RespStatusCode)
+	flusher.Flush()
+	return flusher, nil
+}
+
+// RespSize 响应体的大小，流式响应时是已经写出去的字节数
+func (c *Context) RespSize() int {
+	if c.streaming {
+		return c.streamedBytes
+	}
+	return len(c.RespData)
+}
+
+// streamWrite 流式响应时直接写到Resp里面，并且统计写出去的字节数
+func (c *Context) streamWrite(data []byte) (int, error) {
+	n, err := c.Resp.Write(data)
+	c.streamedBytes += n
+	return n, err
+}
+
+type streamWriter struct {
+	ctx *Context
+}
+
+func (s streamWriter) Write(data []byte) (int, error) {
+	return s.ctx.streamWrite(data)
+}
+
+// Stream 流式响应，每次调用step之后都会Flush，step返回false时结束
+// 返回值表示客户端是否已经断开了连接
+func (c *Context) Stream(step func(w io.Writer) bool) (bool, error) {
+	flusher, err := c.startStream()
+	if err != nil {
+		return false, err
+	}
+	w := streamWriter{ctx: c}
+	done := c.Req.Context().Done()
+	for {
+		select {
+		case <-done:
+			return true, nil
+		default:
+			keepOpen := step(w)
+			flusher.Flush()
+			if !keepOpen {
+				return false, nil
+			}
+		}
+	}
+}
+
+// SSEWriter Server-Sent Events的写入器，Send和心跳可以在不同的goroutine中并发调用
+type SSEWriter struct {
+	ctx     *Context
+	flusher http.Flusher
+	mutex   sync.Mutex
+	closed  bool
+	// 关闭心跳的信号
+	stop chan struct{}
+}
+
+// SSE 进入Server-Sent Events模式，设置好响应头并立刻返回给客户端
+// 用完之后需要调用Close，停止心跳
+func (c *Context) SSE() (*SSEWriter, error) {
+	header := c.Resp.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// 避免nginx这类反向代理缓存响应
+	header.Set("X-Accel-Buffering", "no")
+	flusher, err := c.startStream()
+	if err != nil {
+		return nil, err
+	}
+	return &SSEWriter{
+		ctx:     c,
+		flusher: flusher,
+		stop:    make(chan struct{}),
+	}, nil
+}
+
+// Send 发送一个事件，event和id为空时不发送对应的字段，多行的data会拆成多个data字段
+// event和id中的换行会注入新的字段，直接返回错误
+func (s *SSEWriter) Send(event string, data string, id string) error {
+	if strings.ContainsAny(event, "\r\n") || strings.ContainsAny(id, "\r\n") {
+		return errSSEInvalidField
+	}
+	var sb strings.Builder
+	if id != "" {
+		sb.WriteString("id: " + id + "\n")
+	}
+	if event != "" {
+		sb.WriteString("event: " + event + "\n")
+	}
+	// 规范中\r\n、\r和\n都是换行
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		sb.WriteString("data: " + line + "\n")
+	}
+	sb.WriteString("\n")
+	return s.write(sb.String())
+}
+
+// Heartbeat 每隔interval发送一个注释行，避免连接因为空闲被代理断开，客户端断开或者Close之后停止
+func (s *SSEWriter) Heartbeat(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.write(": ping\n\n"); err != nil {
+					return
+				}
+			case <-s.stop:
+				return
+			case <-s.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Done 客户端断开连接时关闭
+func (s *SSEWriter) Done() <-chan struct{} {
+	return s.ctx.Req.Context().Done()
+}
+
+// Close 停止心跳，之后不能再发送事件。必须在handler返回之前调用
+func (s *SSEWriter) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.stop)
+}
+
+func (s *SSEWriter) write(msg string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return errSSEClosed
+	}
+	if err := s.ctx.Req.Context().Err(); err != nil {
+		return err
+	}
+	if _, err := s.ctx.streamWrite([]byte(msg)); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
@@ -0,0 +1,101 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContext_Stream(t *testing.T) {
+	var status, size int
+	server := NewHTTPServer(ServerWithMiddleware(func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			next(ctx)
+			status, size = ctx.RespStatusCode, ctx.RespSize()
+		}
+	}))
+	server.GET("/stream", func(ctx *Context) {
+		cnt := 0
+		_, err := ctx.Stream(func(w io.Writer) bool {
+			cnt++
+			_, _ = fmt.Fprintf(w, "chunk-%d;", cnt)
+			return cnt < 3
+		})
+		require.NoError(t, err)
+		// 流式响应之后RespData不会再被写出去
+		ctx.RespData = []byte("ignored")
+	})
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/stream", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "chunk-1;chunk-2;chunk-3;", recorder.Body.String())
+	assert.True(t, recorder.Flushed)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, len("chunk-1;chunk-2;chunk-3;"), size)
+}
+
+func TestContext_StreamDisconnect(t *testing.T) {
+	reqCtx, cancel := context.WithCancel(context.Background())
+	ctx := &Context{
+		Req:  httptest.NewRequest(http.MethodGet, "/stream", nil).WithContext(reqCtx),
+		Resp: httptest.NewRecorder(),
+	}
+	cnt := 0
+	gone, err := ctx.Stream(func(w io.Writer) bool {
+		cnt++
+		if cnt == 2 {
+			cancel()
+		}
+		return true
+	})
+	require.NoError(t, err)
+	assert.True(t, gone)
+	assert.Equal(t, 2, cnt)
+}
+
+func TestContext_SSE(t *testing.T) {
+	server := NewHTTPServer()
+	server.GET("/events", func(ctx *Context) {
+		sse, err := ctx.SSE()
+		require.NoError(t, err)
+		defer sse.Close()
+		sse.Heartbeat(10 * time.Millisecond)
+		assert.Equal(t, errSSEInvalidField, sse.Send("greeting\ndata: evil", "hello", ""))
+		assert.Equal(t, errSSEInvalidField, sse.Send("greeting", "hello", "1\r"))
+		require.NoError(t, sse.Send("greeting", "hello\r\nworld\ragain", "1"))
+		require.NoError(t, sse.Send("", "bye", ""))
+		// 等待客户端断开
+		<-sse.Done()
+		assert.Error(t, sse.Send("after", "disconnect", "2"))
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	resp, err := http.Get(httpServer.URL + "/events")
+	require.NoError(t, err)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	// 两个事件一共8行，之后至少有一个心跳
+	for len(lines) < 9 {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+	_ = resp.Body.Close()
+	assert.Equal(t, []string{
+		"id: 1", "event: greeting", "data: hello", "data: world", "data: again", "",
+		"data: bye", "",
+		": ping",
+	}, lines)
+}
@@ -3,7 +3,13 @@ package web
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"html/template"
+	"io/fs"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 )
 
 type TemplateEngine interface {
@@ -11,12 +17,353 @@ type TemplateEngine interface {
 	Render(ctx context.Context, tplName string, data any) ([]byte, error)
 }
 
+type templateFuncsKey struct{}
+
+// WithTemplateFuncs 设置只对当前请求生效的模板函数，例如csrf middleware提供的csrfField
+// 模板在解析的时候必须已经定义了同名的函数，这里只是替换它们的实现
+func WithTemplateFuncs(ctx context.Context, funcs template.FuncMap) context.Context {
+	if old, ok := ctx.Value(templateFuncsKey{}).(template.FuncMap); ok {
+		merged := make(template.FuncMap, len(old)+len(funcs))
+		for name, fn := range old {
+			merged[name] = fn
+		}
+		for name, fn := range funcs {
+			merged[name] = fn
+		}
+		funcs = merged
+	}
+	return context.WithValue(ctx, templateFuncsKey{}, funcs)
+}
+
+func templateFuncs(ctx context.Context) template.FuncMap {
+	funcs, _ := ctx.Value(templateFuncsKey{}).(template.FuncMap)
+	return funcs
+}
+
+type GoTemplateOption func(engine *GoTemplateEngine)
+
+// TemplateWithPages 页面文件的glob，默认是*.html，渲染时使用页面相对于fs.FS的路径作为模板名
+func TemplateWithPages(patterns ...string) GoTemplateOption {
+	return func(engine *GoTemplateEngine) {
+		engine.pages = patterns
+	}
+}
+
+// TemplateWithLayouts 布局文件的glob，布局文件会和每个页面一起解析，页面通过define覆盖布局中的block
+func TemplateWithLayouts(patterns ...string) GoTemplateOption {
+	return func(engine *GoTemplateEngine) {
+		engine.layouts = patterns
+	}
+}
+
+// TemplateWithPartials 公共片段的glob，每个页面都可以通过{{ template "partials/nav.html" . }}引用
+func TemplateWithPartials(patterns ...string) GoTemplateOption {
+	return func(engine *GoTemplateEngine) {
+		engine.partials = patterns
+	}
+}
+
+// TemplateWithDefaultLayout 渲染页面时执行的布局，例如layouts/base.html，没有设置时直接执行页面本身
+func TemplateWithDefaultLayout(name string) GoTemplateOption {
+	return func(engine *GoTemplateEngine) {
+		engine.defaultLayout = name
+	}
+}
+
+// TemplateWithFuncs 注册模板函数，需要在解析之前注册
+func TemplateWithFuncs(funcs template.FuncMap) GoTemplateOption {
+	return func(engine *GoTemplateEngine) {
+		for name, fn := range funcs {
+			engine.funcs[name] = fn
+		}
+	}
+}
+
+// TemplateWithDevMode 开发模式，每次Render之前检查文件是否发生变化，变化了就重新解析
+// 需要配合os.DirFS使用，embed.FS的文件不会变化
+func TemplateWithDevMode() GoTemplateOption {
+	return func(engine *GoTemplateEngine) {
+		engine.devMode = true
+	}
+}
+
+// GoTemplateEngine 基于html/template的模板引擎
+// 可以直接设置解析好的T，也可以通过NewGoTemplateEngine从fs.FS中加载
 type GoTemplateEngine struct {
 	T *template.Template
+
+	// clones 没有执行过的副本，html/template执行过之后就不能再Clone，需要请求级别的函数时从这里Clone
+	once    sync.Once
+	clones  *clonePool
+	baseErr error
+
+	fsys          fs.FS
+	pages         []string
+	layouts       []string
+	partials      []string
+	defaultLayout string
+	funcs         template.FuncMap
+	devMode       bool
+
+	mutex sync.RWMutex
+	// 每个页面单独解析成一个模板集合，不同页面定义的同名block不会冲突
+	sets map[string]*templateSet
+	// 开发模式下用来判断文件是否发生变化
+	signature string
+}
+
+type templateSet struct {
+	exec   *template.Template
+	clones *clonePool
+}
+
+// clonePool 缓存绑定了请求级别函数的副本，每个副本只会Clone和转义一次
+// 副本中注册的是转发函数，执行的时候转发给当前请求设置的实现，所以同一个副本可以给不同的请求使用
+type clonePool struct {
+	base *template.Template
+	// 函数名集合 -> *sync.Pool，函数名不同的副本不能混用，否则缺少的函数没有实现
+	pools sync.Map
+}
+
+func newClonePool(base *template.Template) *clonePool {
+	return &clonePool{base: base}
+}
+
+func (p *clonePool) execute(bs *bytes.Buffer, name string, data any, funcs template.FuncMap) error {
+	names := make([]string, 0, len(funcs))
+	for fnName := range funcs {
+		names = append(names, fnName)
+	}
+	sort.Strings(names)
+	val, _ := p.pools.LoadOrStore(strings.Join(names, ","), &sync.Pool{})
+	pool := val.(*sync.Pool)
+	bound, _ := pool.Get().(*boundTemplate)
+	if bound == nil {
+		clone, err := p.base.Clone()
+		if err != nil {
+			return err
+		}
+		bound = &boundTemplate{tpl: clone, types: make(map[string]reflect.Type, len(funcs))}
+	}
+	bound.bind(funcs)
+	err := bound.tpl.ExecuteTemplate(bs, name, data)
+	// 不要让副本持有请求的数据
+	bound.funcs = nil
+	pool.Put(bound)
+	return err
+}
+
+type boundTemplate struct {
+	tpl   *template.Template
+	funcs template.FuncMap
+	// 已经注册的转发函数的类型，类型变化时重新注册
+	types map[string]reflect.Type
+}
+
+func (b *boundTemplate) bind(funcs template.FuncMap) {
+	b.funcs = funcs
+	var forwards template.FuncMap
+	for name, fn := range funcs {
+		typ := reflect.TypeOf(fn)
+		if b.types[name] == typ {
+			continue
+		}
+		if forwards == nil {
+			forwards = make(template.FuncMap, len(funcs))
+		}
+		fnName := name
+		forwards[name] = reflect.MakeFunc(typ, func(args []reflect.Value) []reflect.Value {
+			return reflect.ValueOf(b.funcs[fnName]).Call(args)
+		}).Interface()
+		b.types[name] = typ
+	}
+	if forwards != nil {
+		b.tpl.Funcs(forwards)
+	}
+}
+
+// NewGoTemplateEngine 从fsys中加载模板，fsys可以是embed.FS，也可以是os.DirFS
+//
+//	//go:embed templates
+//	var templates embed.FS
+//	sub, _ := fs.Sub(templates, "templates")
+//	engine, err := web.NewGoTemplateEngine(sub,
+//		web.TemplateWithPages("pages/*.html"),
+//		web.TemplateWithLayouts("layouts/*.html"),
+//		web.TemplateWithPartials("partials/*.html"),
+//		web.TemplateWithDefaultLayout("layouts/base.html"))
+func NewGoTemplateEngine(fsys fs.FS, opts ...GoTemplateOption) (*GoTemplateEngine, error) {
+	res := &GoTemplateEngine{
+		fsys:  fsys,
+		pages: []string{"*.html"},
+		funcs: template.FuncMap{},
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	if err := res.load(); err != nil {
+		return nil, err
+	}
+	return res, nil
 }
 
 func (g *GoTemplateEngine) Render(ctx context.Context, tplName string, data any) ([]byte, error) {
+	if g.fsys == nil {
+		return g.renderT(ctx, tplName, data)
+	}
+	if g.devMode {
+		if err := g.reloadIfModified(); err != nil {
+			return nil, err
+		}
+	}
+	g.mutex.RLock()
+	set, ok := g.sets[tplName]
+	g.mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("web: 找不到模板 %s", tplName)
+	}
+	name := tplName
+	if g.defaultLayout != "" {
+		name = g.defaultLayout
+	}
+	bs := &bytes.Buffer{}
+	var err error
+	if funcs := templateFuncs(ctx); len(funcs) > 0 {
+		err = set.clones.execute(bs, name, data, funcs)
+	} else {
+		err = set.exec.ExecuteTemplate(bs, name, data)
+	}
+	return bs.Bytes(), err
+}
+
+// renderT 直接使用解析好的T
+func (g *GoTemplateEngine) renderT(ctx context.Context, tplName string, data any) ([]byte, error) {
+	g.once.Do(func() {
+		var base *template.Template
+		base, g.baseErr = g.T.Clone()
+		g.clones = newClonePool(base)
+	})
 	bs := &bytes.Buffer{}
-	err := g.T.ExecuteTemplate(bs, tplName, data)
+	funcs := templateFuncs(ctx)
+	if len(funcs) == 0 {
+		err := g.T.ExecuteTemplate(bs, tplName, data)
+		return bs.Bytes(), err
+	}
+	if g.baseErr != nil {
+		return nil, g.baseErr
+	}
+	err := g.clones.execute(bs, tplName, data, funcs)
 	return bs.Bytes(), err
 }
+
+// load 解析所有的页面，任何一个文件有问题都返回error，原来的模板保持不变
+func (g *GoTemplateEngine) load() error {
+	shared, err := g.glob(append(append([]string{}, g.layouts...), g.partials...))
+	if err != nil {
+		return err
+	}
+	pages, err := g.glob(g.pages)
+	if err != nil {
+		return err
+	}
+	signature, err := g.sign(append(append([]string{}, shared...), pages...))
+	if err != nil {
+		return err
+	}
+
+	// 公共的部分只解析一次，每个页面在它的副本上解析
+	common := template.New("").Funcs(g.funcs)
+	for _, name := range shared {
+		if err = g.parseFile(common, name); err != nil {
+			return err
+		}
+	}
+	isShared := make(map[string]bool, len(shared))
+	for _, name := range shared {
+		isShared[name] = true
+	}
+	sets := make(map[string]*templateSet, len(pages))
+	for _, name := range pages {
+		if isShared[name] {
+			continue
+		}
+		base, err := common.Clone()
+		if err != nil {
+			return err
+		}
+		if err = g.parseFile(base, name); err != nil {
+			return err
+		}
+		exec, err := base.Clone()
+		if err != nil {
+			return err
+		}
+		sets[name] = &templateSet{exec: exec, clones: newClonePool(base)}
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.sets = sets
+	g.signature = signature
+	return nil
+}
+
+func (g *GoTemplateEngine) parseFile(tpl *template.Template, name string) error {
+	data, err := fs.ReadFile(g.fsys, name)
+	if err != nil {
+		return err
+	}
+	_, err = tpl.New(name).Parse(string(data))
+	return err
+}
+
+func (g *GoTemplateEngine) reloadIfModified() error {
+	names, err := g.glob(append(append(append([]string{}, g.layouts...), g.partials...), g.pages...))
+	if err != nil {
+		return err
+	}
+	signature, err := g.sign(names)
+	if err != nil {
+		return err
+	}
+	g.mutex.RLock()
+	modified := signature != g.signature
+	g.mutex.RUnlock()
+	if !modified {
+		return nil
+	}
+	return g.load()
+}
+
+// glob 返回去重并且排好序的文件名
+func (g *GoTemplateEngine) glob(patterns []string) ([]string, error) {
+	seen := make(map[string]bool, 16)
+	res := make([]string, 0, 16)
+	for _, pattern := range patterns {
+		names, err := fs.Glob(g.fsys, pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				res = append(res, name)
+			}
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// sign 文件名、大小和修改时间组成的签名，任何一个文件变化、新增、删除都会导致签名变化
+func (g *GoTemplateEngine) sign(names []string) (string, error) {
+	var sb strings.Builder
+	for _, name := range names {
+		info, err := fs.Stat(g.fsys, name)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&sb, "%s:%d:%d;", name, info.Size(), info.ModTime().UnixNano())
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,96 @@
+package web
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"html/template"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestNewGoTemplateEngine(t *testing.T) {
+	fsys := fstest.MapFS{
+		"layouts/base.html": {Data: []byte(`<html>{{ template "partials/nav.html" . }}{{ block "content" . }}{{ end }}</html>`)},
+		"partials/nav.html": {Data: []byte(`<nav>{{ upper .Name }}</nav>`)},
+		"pages/home.html":   {Data: []byte(`{{ define "content" }}home {{ .Name }}{{ end }}`)},
+		"pages/about.html":  {Data: []byte(`{{ define "content" }}about{{ end }}`)},
+	}
+	engine, err := NewGoTemplateEngine(fsys,
+		TemplateWithPages("pages/*.html"),
+		TemplateWithLayouts("layouts/*.html"),
+		TemplateWithPartials("partials/*.html"),
+		TemplateWithDefaultLayout("layouts/base.html"),
+		TemplateWithFuncs(template.FuncMap{"upper": strings.ToUpper}))
+	require.NoError(t, err)
+
+	data := map[string]string{"Name": "Tom"}
+	res, err := engine.Render(context.Background(), "pages/home.html", data)
+	require.NoError(t, err)
+	assert.Equal(t, "<html><nav>TOM</nav>home Tom</html>", string(res))
+
+	// 不同页面的同名block互不影响
+	res, err = engine.Render(context.Background(), "pages/about.html", data)
+	require.NoError(t, err)
+	assert.Equal(t, "<html><nav>TOM</nav>about</html>", string(res))
+
+	_, err = engine.Render(context.Background(), "pages/missing.html", data)
+	assert.Error(t, err)
+
+	// 请求级别的函数
+	ctx := WithTemplateFuncs(context.Background(), template.FuncMap{"upper": strings.ToLower})
+	res, err = engine.Render(ctx, "pages/home.html", data)
+	require.NoError(t, err)
+	assert.Equal(t, "<html><nav>tom</nav>home Tom</html>", string(res))
+
+	// 副本会被复用，但是每个请求使用自己的实现
+	for _, name := range []string{"JERRY", "Tom"} {
+		want := name
+		ctx = WithTemplateFuncs(context.Background(), template.FuncMap{"upper": func(string) string { return want }})
+		res, err = engine.Render(ctx, "pages/home.html", data)
+		require.NoError(t, err)
+		assert.Equal(t, "<html><nav>"+want+"</nav>home Tom</html>", string(res))
+	}
+	// 没有请求级别的函数时使用注册的实现
+	res, err = engine.Render(context.Background(), "pages/home.html", data)
+	require.NoError(t, err)
+	assert.Equal(t, "<html><nav>TOM</nav>home Tom</html>", string(res))
+}
+
+func TestNewGoTemplateEngine_ParseError(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": {Data: []byte(`{{ .Name `)},
+	}
+	_, err := NewGoTemplateEngine(fsys)
+	assert.Error(t, err)
+}
+
+func TestGoTemplateEngine_DevMode(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": {Data: []byte(`v1`), ModTime: time.Unix(1, 0)},
+	}
+	engine, err := NewGoTemplateEngine(fsys, TemplateWithDevMode())
+	require.NoError(t, err)
+	res, err := engine.Render(context.Background(), "index.html", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(res))
+
+	fsys["index.html"] = &fstest.MapFile{Data: []byte(`v2`), ModTime: time.Unix(2, 0)}
+	fsys["new.html"] = &fstest.MapFile{Data: []byte(`new`)}
+	res, err = engine.Render(context.Background(), "index.html", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "v2", string(res))
+	res, err = engine.Render(context.Background(), "new.html", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(res))
+
+	// 非开发模式不会重新加载
+	engine, err = NewGoTemplateEngine(fsys)
+	require.NoError(t, err)
+	fsys["index.html"] = &fstest.MapFile{Data: []byte(`v3`), ModTime: time.Unix(3, 0)}
+	res, err = engine.Render(context.Background(), "index.html", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "v2", string(res))
+}
@@ -2,12 +2,26 @@ package test
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"github.com/dongma/imola/web"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"html/template"
+	"io"
+	"mime"
 	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
+	"os"
 	"path"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 func TestFileUploader_Handle(t *testing.T) {
@@ -60,3 +74,371 @@ func TestStaticResource_Handler(t *testing.T) {
 	// 在浏览器中输入 http://localhost:8081/img/come_on_baby.jpg
 	server.Start(":8081")
 }
+
+func TestStaticResource_CatchAll(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "css", "app"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "css", "app", "main.png"), []byte("png data"), 0o644))
+
+	server := web.NewHTTPServer()
+	handler := web.NewStaticResourceHandler(dir, "static", web.WithPathParam("filepath"))
+	server.GET("/static/*filepath", handler.Handle)
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/static/css/app/main.png", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "png data", recorder.Body.String())
+
+	// 不能通过 ../ 访问到目录之外的文件
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/static/../../etc/passwd.png", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/static/css/app/missing.png", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func newUploadRequest(t *testing.T, field string, files map[string][]byte) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		part, err := writer.CreateFormFile(field, name)
+		require.NoError(t, err)
+		_, err = part.Write(files[name])
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.WriteField("desc", "ignored"))
+	require.NoError(t, writer.Close())
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestFileUploader_Multiple(t *testing.T) {
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 32)...)
+	testCases := []struct {
+		name     string
+		files    map[string][]byte
+		wantCode int
+		wantCnt  int
+	}{
+		{
+			name:     "multiple files",
+			files:    map[string][]byte{"a.png": png, "../../b.PNG": png},
+			wantCode: http.StatusOK,
+			wantCnt:  2,
+		},
+		{
+			name:     "no file",
+			files:    map[string][]byte{},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "too many files",
+			files:    map[string][]byte{"a.png": png, "b.png": png, "c.png": png},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "too large",
+			files:    map[string][]byte{"a.png": append(png, make([]byte, 64)...)},
+			wantCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:     "ext not allowed",
+			files:    map[string][]byte{"a.png": png, "b.exe": png},
+			wantCode: http.StatusUnsupportedMediaType,
+		},
+		{
+			// 扩展名是png，内容其实是文本
+			name:     "mime not allowed",
+			files:    map[string][]byte{"a.png": []byte("hello world")},
+			wantCode: http.StatusUnsupportedMediaType,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			server := web.NewHTTPServer()
+			server.POST("/upload", (&web.FileUploader{
+				FileField:        "myfile",
+				Dir:              dir,
+				MaxFileSize:      64,
+				MaxFiles:         2,
+				AllowedExts:      []string{".png", "jpg"},
+				AllowedMIMETypes: []string{"image/*"},
+			}).Handle())
+
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, newUploadRequest(t, "myfile", tc.files))
+			assert.Equal(t, tc.wantCode, recorder.Code)
+
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			// 失败的时候已经保存的文件也要删除，也不能留下临时文件
+			assert.Len(t, entries, tc.wantCnt)
+			if tc.wantCode != http.StatusOK {
+				return
+			}
+			var files []web.UploadedFile
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &files))
+			sum := sha256.Sum256(png)
+			for _, file := range files {
+				assert.Equal(t, int64(len(png)), file.Size)
+				assert.Equal(t, hex.EncodeToString(sum[:]), file.SHA256)
+				assert.Equal(t, "image/png", file.ContentType)
+				assert.Empty(t, file.Path)
+				_, err = os.Stat(filepath.Join(dir, file.Name))
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSafeFileName(t *testing.T) {
+	assert.Equal(t, "passwd", web.SafeFileName("../../etc/passwd"))
+	assert.Equal(t, "a.jpg", web.SafeFileName(`C:\Users\tom\a.jpg`))
+	assert.Equal(t, "htaccess", web.SafeFileName(".htaccess"))
+	assert.Equal(t, "my_photo_1_.png", web.SafeFileName("my photo(1).png"))
+	assert.Equal(t, "头像.png", web.SafeFileName("头像.png"))
+	assert.Equal(t, "file", web.SafeFileName(".."))
+}
+
+func TestChunkUploader(t *testing.T) {
+	dir := t.TempDir()
+	uploader := &web.ChunkUploader{Dir: dir, MaxFileSize: 1 << 20, AllowedExts: []string{".bin"}}
+	server := web.NewHTTPServer()
+	server.POST("/uploads", uploader.Init())
+	server.GET("/uploads/:id", uploader.Status())
+	server.PUT("/uploads/:id/chunks/:index", uploader.Chunk())
+	server.POST("/uploads/:id/complete", uploader.Complete())
+	server.DELETE("/uploads/:id", uploader.Abort())
+
+	do := func(method, path string, body []byte, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	data := bytes.Repeat([]byte("0123456789"), 25)
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+
+	recorder := do(http.MethodPost, "/uploads", []byte(`{"filename":"a.exe","size":250,"chunk_size":100}`), "Content-Type", "application/json")
+	assert.Equal(t, http.StatusUnsupportedMediaType, recorder.Code)
+
+	recorder = do(http.MethodPost, "/uploads",
+		[]byte(`{"filename":"data.bin","size":250,"chunk_size":100,"sha256":"`+checksum+`"}`),
+		"Content-Type", "application/json")
+	require.Equal(t, http.StatusCreated, recorder.Code)
+	var status web.ChunkUploadStatus
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	assert.Equal(t, 3, status.Chunks)
+	assert.Empty(t, status.Received)
+	base := "/uploads/" + status.UploadID
+
+	// 分片大小不对
+	recorder = do(http.MethodPut, base+"/chunks/0", data[:99])
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	// 分片校验和不对
+	recorder = do(http.MethodPut, base+"/chunks/0", data[:100], "X-Chunk-Sha256", checksum)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	// 序号越界
+	recorder = do(http.MethodPut, base+"/chunks/3", data[:50])
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	// 乱序上传，中途断开
+	recorder = do(http.MethodPut, base+"/chunks/2", data[200:])
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	chunk0 := sha256.Sum256(data[:100])
+	recorder = do(http.MethodPut, base+"/chunks/0", data[:100], "X-Chunk-Sha256", hex.EncodeToString(chunk0[:]))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	recorder = do(http.MethodPost, base+"/complete", nil)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	// 续传缺少的分片
+	recorder = do(http.MethodGet, base, nil)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	assert.Equal(t, []int{0, 2}, status.Received)
+	recorder = do(http.MethodPut, base+"/chunks/1", data[100:200])
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	recorder = do(http.MethodPost, base+"/complete", nil)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var file web.UploadedFile
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &file))
+	assert.Equal(t, checksum, file.SHA256)
+	assert.Equal(t, int64(250), file.Size)
+	saved, err := os.ReadFile(filepath.Join(dir, file.Name))
+	require.NoError(t, err)
+	assert.Equal(t, data, saved)
+
+	// 完成之后任务被删除
+	recorder = do(http.MethodGet, base, nil)
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	recorder = do(http.MethodGet, "/uploads/..", nil)
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestChunkUploader_CleanExpired(t *testing.T) {
+	dir := t.TempDir()
+	uploader := &web.ChunkUploader{Dir: dir}
+	server := web.NewHTTPServer()
+	server.POST("/uploads", uploader.Init())
+	req := httptest.NewRequest(http.MethodPost, "/uploads", strings.NewReader(`{"filename":"a.bin","size":10}`))
+	req.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusCreated, recorder.Code)
+
+	require.NoError(t, uploader.CleanExpired(time.Hour))
+	entries, err := os.ReadDir(filepath.Join(dir, ".chunks"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+	require.NoError(t, uploader.CleanExpired(0))
+	entries, err = os.ReadDir(filepath.Join(dir, ".chunks"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 0)
+}
+
+func TestStaticResource_Conditional(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"img/logo.png": {Data: []byte("0123456789abcdef"), ModTime: modTime},
+	}
+	for _, cached := range []bool{false, true} {
+		opts := []web.StaticResourceHandlerOption{
+			web.WithPathParam("filepath"),
+			web.WithCacheControl("public, max-age=3600"),
+		}
+		if cached {
+			opts = append(opts, web.WithFileCache(1<<20, 16))
+		}
+		server := web.NewHTTPServer()
+		server.GET("/static/*filepath", web.NewStaticResourceHandlerFS(fsys, opts...).Handle)
+		do := func(header ...string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/static/img/logo.png", nil)
+			for i := 0; i+1 < len(header); i += 2 {
+				req.Header.Set(header[i], header[i+1])
+			}
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			return recorder
+		}
+
+		recorder := do()
+		require.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "image/png", recorder.Header().Get("Content-Type"))
+		assert.Equal(t, "public, max-age=3600", recorder.Header().Get("Cache-Control"))
+		assert.Equal(t, modTime.Format(http.TimeFormat), recorder.Header().Get("Last-Modified"))
+		assert.Equal(t, "16", recorder.Header().Get("Content-Length"))
+		etag := recorder.Header().Get("ETag")
+		require.NotEmpty(t, etag)
+
+		recorder = do("If-None-Match", etag)
+		assert.Equal(t, http.StatusNotModified, recorder.Code)
+		assert.Empty(t, recorder.Body.String())
+		recorder = do("If-Modified-Since", modTime.Add(time.Hour).Format(http.TimeFormat))
+		assert.Equal(t, http.StatusNotModified, recorder.Code)
+		recorder = do("If-None-Match", `"other"`)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		recorder = do("Range", "bytes=2-5")
+		assert.Equal(t, http.StatusPartialContent, recorder.Code)
+		assert.Equal(t, "2345", recorder.Body.String())
+		assert.Equal(t, "bytes 2-5/16", recorder.Header().Get("Content-Range"))
+
+		// If-Range不匹配的时候返回整个文件
+		recorder = do("Range", "bytes=2-5", "If-Range", `"other"`)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "0123456789abcdef", recorder.Body.String())
+		recorder = do("Range", "bytes=2-5", "If-Range", etag)
+		assert.Equal(t, http.StatusPartialContent, recorder.Code)
+
+		recorder = do("Range", "bytes=0-1,-2")
+		assert.Equal(t, http.StatusPartialContent, recorder.Code)
+		mediaType, params, err := mime.ParseMediaType(recorder.Header().Get("Content-Type"))
+		require.NoError(t, err)
+		assert.Equal(t, "multipart/byteranges", mediaType)
+		reader := multipart.NewReader(recorder.Body, params["boundary"])
+		var parts []string
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+			data, _ := io.ReadAll(part)
+			parts = append(parts, part.Header.Get("Content-Range")+"="+string(data))
+		}
+		assert.Equal(t, []string{"bytes 0-1/16=01", "bytes 14-15/16=ef"}, parts)
+
+		recorder = do("Range", "bytes=100-200")
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, recorder.Code)
+
+		// 文件修改之后不能返回缓存中的旧内容
+		newModTime := modTime.Add(time.Hour)
+		fsys["img/logo.png"] = &fstest.MapFile{Data: []byte("new logo"), ModTime: newModTime}
+		recorder = do("If-None-Match", etag)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "new logo", recorder.Body.String())
+		assert.NotEqual(t, etag, recorder.Header().Get("ETag"))
+		assert.Equal(t, newModTime.Format(http.TimeFormat), recorder.Header().Get("Last-Modified"))
+		fsys["img/logo.png"] = &fstest.MapFile{Data: []byte("0123456789abcdef"), ModTime: modTime}
+
+		// 删除之后返回404
+		delete(fsys, "img/logo.png")
+		assert.Equal(t, http.StatusNotFound, do().Code)
+		fsys["img/logo.png"] = &fstest.MapFile{Data: []byte("0123456789abcdef"), ModTime: modTime}
+	}
+}
+
+func TestFileDownloader_Range(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "report 1.txt"), []byte("hello world"), 0o644))
+	server := web.NewHTTPServer()
+	server.GET("/download/*filepath", (&web.FileDownloader{Dir: dir, PathParam: "filepath"}).Handle())
+	server.GET("/embed", (&web.FileDownloader{FS: fstest.MapFS{
+		"a.txt": {Data: []byte("embedded")},
+	}}).Handle())
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/download/report%201.txt", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, `attachment; filename="report 1.txt"`, recorder.Header().Get("Content-Disposition"))
+	assert.Equal(t, "must-revalidate", recorder.Header().Get("Cache-Control"))
+	etag := recorder.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	req := httptest.NewRequest(http.MethodGet, "/download/report%201.txt", nil)
+	req.Header.Set("Range", "bytes=6-")
+	req.Header.Set("If-Range", etag)
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusPartialContent, recorder.Code)
+	assert.Equal(t, "world", recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/download/../../etc/passwd", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	// 没有修改时间的文件根据内容生成ETag
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/embed?file=a.txt", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "embedded", recorder.Body.String())
+	req = httptest.NewRequest(http.MethodGet, "/embed?file=a.txt", nil)
+	req.Header.Set("If-None-Match", recorder.Header().Get("ETag"))
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusNotModified, recorder.Code)
+}
@@ -0,0 +1,213 @@
+package web
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ServerWithTLSConfig 设置TLS的基础配置，例如MinVersion、CipherSuites，只在StartTLS、ServeTLS时生效
+func ServerWithTLSConfig(cfg *tls.Config) HTTPServerOption {
+	return func(server *HTTPServer) {
+		server.tlsConfig = cfg
+	}
+}
+
+// ServerWithGetCertificate 通过回调获取证书，设置之后StartTLS可以不传证书文件，例如配合CertReloader实现证书热更新
+func ServerWithGetCertificate(fn func(hello *tls.ClientHelloInfo) (*tls.Certificate, error)) HTTPServerOption {
+	return func(server *HTTPServer) {
+		server.getCertificate = fn
+	}
+}
+
+// ServerWithClientCAs 开启双向TLS认证，clientCAs用于校验客户端证书
+// authType一般是tls.RequireAndVerifyClientCert，客户端证书可选时使用tls.VerifyClientCertIfGiven
+func ServerWithClientCAs(clientCAs *x509.CertPool, authType tls.ClientAuthType) HTTPServerOption {
+	return func(server *HTTPServer) {
+		server.clientCAs = clientCAs
+		server.clientAuth = authType
+	}
+}
+
+// ServerWithH2C 明文的HTTP/2，一般用于内部服务之间的通信，TLS下HTTP/2默认就是开启的
+func ServerWithH2C() HTTPServerOption {
+	return func(server *HTTPServer) {
+		server.h2c = true
+	}
+}
+
+// StartTLS 使用TLS启动服务器，HTTP/2会自动开启
+// 通过ServerWithGetCertificate设置了证书回调时，certFile和keyFile可以为空
+func (h *HTTPServer) StartTLS(addr string, certFile string, keyFile string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return h.ServeTLS(listener, certFile, keyFile)
+}
+
+// ServeTLS 在listener上接收TLS请求，和Serve一样，Shutdown之后返回http.ErrServerClosed
+func (h *HTTPServer) ServeTLS(listener net.Listener, certFile string, keyFile string) error {
+	if certFile == "" && h.getCertificate == nil &&
+		(h.tlsConfig == nil || len(h.tlsConfig.Certificates) == 0 && h.tlsConfig.GetCertificate == nil) {
+		_ = listener.Close()
+		return errors.New("web: 启动TLS需要证书文件或者GetCertificate回调")
+	}
+	srv, err := h.prepare(listener)
+	if err != nil {
+		return err
+	}
+	return srv.ServeTLS(listener, certFile, keyFile)
+}
+
+// newServer 根据配置创建底层的http.Server
+func (h *HTTPServer) newServer() *http.Server {
+	var handler http.Handler = h
+	if h.h2c {
+		// TLS的请求不会经过h2c，h2c.NewHandler只处理明文的HTTP/2
+		handler = h2c.NewHandler(h, &http2.Server{})
+	}
+	srv := &http.Server{Handler: handler}
+	if h.tlsConfig != nil || h.getCertificate != nil || h.clientCAs != nil {
+		cfg := &tls.Config{}
+		if h.tlsConfig != nil {
+			cfg = h.tlsConfig.Clone()
+		}
+		if h.getCertificate != nil {
+			cfg.GetCertificate = h.getCertificate
+		}
+		if h.clientCAs != nil {
+			cfg.ClientCAs = h.clientCAs
+			cfg.ClientAuth = h.clientAuth
+		}
+		srv.TLSConfig = cfg
+	}
+	return srv
+}
+
+// ClientCertInfo 双向TLS认证时客户端证书的信息
+type ClientCertInfo struct {
+	CommonName   string
+	Organization []string
+	DNSNames     []string
+	Emails       []string
+	SerialNumber *big.Int
+	Issuer       string
+	NotBefore    time.Time
+	NotAfter     time.Time
+	// Fingerprint 证书DER编码的SHA-256，十六进制
+	Fingerprint string
+	// Certificate 原始的证书，需要更多信息时使用
+	Certificate *x509.Certificate
+}
+
+// ClientCert 客户端证书的信息，不是TLS请求或者客户端没有提供证书时返回nil
+func (c *Context) ClientCert() *ClientCertInfo {
+	if c.Req.TLS == nil || len(c.Req.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	cert := c.Req.TLS.PeerCertificates[0]
+	sum := sha256.Sum256(cert.Raw)
+	return &ClientCertInfo{
+		CommonName:   cert.Subject.CommonName,
+		Organization: cert.Subject.Organization,
+		DNSNames:     cert.DNSNames,
+		Emails:       cert.EmailAddresses,
+		SerialNumber: cert.SerialNumber,
+		Issuer:       cert.Issuer.String(),
+		NotBefore:    cert.NotBefore,
+		NotAfter:     cert.NotAfter,
+		Fingerprint:  hex.EncodeToString(sum[:]),
+		Certificate:  cert,
+	}
+}
+
+// CertReloader 证书文件在磁盘上发生变化时重新加载，不需要重启服务器
+//
+//	reloader, err := web.NewCertReloader("server.crt", "server.key", time.Minute)
+//	server := web.NewHTTPServer(web.ServerWithGetCertificate(reloader.GetCertificate))
+//	server.StartTLS(":443", "", "")
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	// 两次检查文件之间的最小间隔，避免每次握手都读取文件信息，0表示每次握手都检查
+	interval time.Duration
+
+	mutex     sync.RWMutex
+	cert      *tls.Certificate
+	certMod   time.Time
+	keyMod    time.Time
+	lastCheck time.Time
+}
+
+// NewCertReloader 创建的时候加载一次证书，证书有问题时直接返回error
+func NewCertReloader(certFile string, keyFile string, interval time.Duration) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: interval,
+	}
+	certMod, keyMod, err := r.modTime()
+	if err != nil {
+		return nil, err
+	}
+	if err = r.load(certMod, keyMod); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate 作为tls.Config.GetCertificate使用
+// 重新加载失败的时候继续使用旧的证书，例如证书和私钥只更新了其中一个
+func (r *CertReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	cert, lastCheck := r.cert, r.lastCheck
+	r.mutex.RUnlock()
+	if time.Since(lastCheck) < r.interval {
+		return cert, nil
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.lastCheck = time.Now()
+	certMod, keyMod, err := r.modTime()
+	if err != nil || certMod.Equal(r.certMod) && keyMod.Equal(r.keyMod) {
+		return r.cert, nil
+	}
+	_ = r.load(certMod, keyMod)
+	return r.cert, nil
+}
+
+// load 调用方需要持有锁，或者还没有并发访问
+func (r *CertReloader) load(certMod time.Time, keyMod time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.certMod, r.keyMod = certMod, keyMod
+	r.lastCheck = time.Now()
+	return nil
+}
+
+func (r *CertReloader) modTime() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
@@ -0,0 +1,218 @@
+package web
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert 生成测试用的证书，parent为nil时是自签名的CA证书
+func newTestCert(t *testing.T, cn string, parent *testCert, isClient bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	tpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"imola"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	signer, signerKey := tpl, key
+	if parent == nil {
+		tpl.IsCA = true
+		tpl.BasicConstraintsValid = true
+		tpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+		if isClient {
+			tpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		} else {
+			tpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+			tpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (c *testCert) writeFiles(t *testing.T, dir string) (string, string) {
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	require.NoError(t, os.WriteFile(certFile, c.certPEM, 0600))
+	require.NoError(t, os.WriteFile(keyFile, c.keyPEM, 0600))
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCert(t *testing.T) tls.Certificate {
+	cert, err := tls.X509KeyPair(c.certPEM, c.keyPEM)
+	require.NoError(t, err)
+	return cert
+}
+
+// startTLSServer 在随机端口上启动服务器，返回地址
+func startTLSServer(t *testing.T, server *HTTPServer, certFile string, keyFile string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = server.ServeTLS(listener, certFile, keyFile)
+	}()
+	t.Cleanup(func() {
+		_ = server.Shutdown(context.Background())
+	})
+	return listener.Addr().String()
+}
+
+func TestHTTPServer_StartTLS(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, false)
+	serverCert := newTestCert(t, "server", ca, false)
+	clientCert := newTestCert(t, "client", ca, true)
+	certFile, keyFile := serverCert.writeFiles(t, t.TempDir())
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	server := NewHTTPServer(ServerWithClientCAs(pool, tls.VerifyClientCertIfGiven))
+	server.GET("/whoami", func(ctx *Context) {
+		info := ctx.ClientCert()
+		if info == nil {
+			ctx.RespData = []byte(ctx.Req.Proto + " anonymous")
+			return
+		}
+		ctx.RespData = []byte(ctx.Req.Proto + " " + info.CommonName)
+	})
+	addr := startTLSServer(t, server, certFile, keyFile)
+
+	testCases := []struct {
+		name     string
+		certs    []tls.Certificate
+		wantBody string
+	}{
+		{
+			name:     "anonymous",
+			wantBody: "HTTP/2.0 anonymous",
+		},
+		{
+			name:     "client cert",
+			certs:    []tls.Certificate{clientCert.tlsCert(t)},
+			wantBody: "HTTP/2.0 client",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &http.Client{Transport: &http.Transport{
+				TLSClientConfig:   &tls.Config{RootCAs: pool, Certificates: tc.certs},
+				ForceAttemptHTTP2: true,
+			}}
+			resp, err := client.Get("https://" + addr + "/whoami")
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantBody, string(body))
+		})
+	}
+}
+
+func TestHTTPServer_StartTLSWithoutCert(t *testing.T) {
+	server := NewHTTPServer()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	assert.Error(t, server.ServeTLS(listener, "", ""))
+}
+
+func TestCertReloader_GetCertificate(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, false)
+	dir := t.TempDir()
+	certFile, keyFile := newTestCert(t, "old", ca, false).writeFiles(t, dir)
+	reloader, err := NewCertReloader(certFile, keyFile, 0)
+	require.NoError(t, err)
+
+	server := NewHTTPServer(ServerWithGetCertificate(reloader.GetCertificate))
+	server.GET("/", func(ctx *Context) {
+		ctx.RespData = []byte("ok")
+	})
+	addr := startTLSServer(t, server, "", "")
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	peerCN := func() string {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pool})
+		require.NoError(t, err)
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+	assert.Equal(t, "old", peerCN())
+
+	// 替换证书文件，并且保证修改时间发生变化
+	newTestCert(t, "new", ca, false).writeFiles(t, dir)
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, later, later))
+	require.NoError(t, os.Chtimes(keyFile, later, later))
+	assert.Equal(t, "new", peerCN())
+
+	// 文件内容有问题时继续使用旧的证书
+	require.NoError(t, os.WriteFile(keyFile, []byte("broken"), 0600))
+	later = later.Add(time.Minute)
+	require.NoError(t, os.Chtimes(keyFile, later, later))
+	assert.Equal(t, "new", peerCN())
+}
+
+func TestHTTPServer_H2C(t *testing.T) {
+	server := NewHTTPServer(ServerWithH2C())
+	server.GET("/proto", func(ctx *Context) {
+		ctx.RespData = []byte(ctx.Req.Proto)
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Shutdown(context.Background())
+
+	// 使用明文的HTTP/2客户端
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	resp, err := client.Get("http://" + listener.Addr().String() + "/proto")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/2.0", string(body))
+}
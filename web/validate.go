@@ -0,0 +1,226 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 校验时使用的struct tag，规则之间用逗号分隔，例如
+//
+//	type UserReq struct {
+//		Name  string `query:"name" validate:"required,min=1,max=64"`
+//		Email string `form:"email" validate:"omitempty,email"`
+//		Role  string `form:"role" validate:"oneof=admin user"`
+//	}
+//
+// 支持的规则：
+//   - required 不能是零值，切片和map长度不能为0
+//   - omitempty 字段为零值时跳过后面的规则
+//   - min、max 数字比较大小，字符串比较字符数，切片和map比较长度
+//   - email 合法的邮箱地址
+//   - oneof 必须是空格分隔的候选值之一
+const validateTag = "validate"
+
+// FieldError 单个字段的校验错误，Field是结构体字段名，嵌套结构体使用.连接
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// ValidationError 包含所有没有通过校验的字段
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fe.Field+": "+fe.Message)
+	}
+	return "web: 参数校验失败, " + strings.Join(msgs, "; ")
+}
+
+// Validate 按照validate tag校验结构体，val不是结构体或者结构体指针时直接返回nil
+// 所有没有通过校验的字段会放在*ValidationError中返回，规则本身写错时返回普通的error
+func Validate(val any) error {
+	return validateSource(val, "")
+}
+
+// validateSource source不为空时只校验带有该绑定tag的字段，例如BindQuery只校验带有query tag的字段，
+// 其它字段还没有绑定，校验它们没有意义
+func validateSource(val any, source string) error {
+	v := reflect.ValueOf(val)
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	res := &ValidationError{}
+	if err := validateStruct(v, "", source, res); err != nil {
+		return err
+	}
+	if len(res.Errors) > 0 {
+		return res
+	}
+	return nil
+}
+
+func validateStruct(v reflect.Value, prefix string, source string, res *ValidationError) error {
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		fv := v.Field(i)
+		name := prefix + field.Name
+		if field.Anonymous {
+			name = strings.TrimSuffix(prefix, ".")
+		}
+		if !field.IsExported() && !field.Anonymous {
+			continue
+		}
+		if tag := field.Tag.Get(validateTag); tag != "" && tag != "-" && fromSource(field, source) {
+			if err := validateField(fv, name, tag, res); err != nil {
+				return err
+			}
+		}
+		// 嵌套的结构体继续校验，单独的数据来源和绑定一样只会展开嵌入的结构体
+		for fv.Kind() == reflect.Pointer && !fv.IsNil() {
+			fv = fv.Elem()
+		}
+		if fv.Kind() == reflect.Struct && fv.Type() != timeType && (source == "" || field.Anonymous) {
+			nextPrefix := name + "."
+			if name == "" {
+				nextPrefix = ""
+			}
+			if err := validateStruct(fv, nextPrefix, source, res); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func fromSource(field reflect.StructField, source string) bool {
+	if source == "" {
+		return true
+	}
+	key, _, _ := strings.Cut(field.Tag.Get(source), ",")
+	return key != "" && key != "-"
+}
+
+func validateField(fv reflect.Value, name string, tag string, res *ValidationError) error {
+	for _, rule := range strings.Split(tag, ",") {
+		rule, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch rule {
+		case "omitempty":
+			if isEmptyValue(fv) {
+				return nil
+			}
+			continue
+		case "required":
+			if isEmptyValue(fv) {
+				res.Errors = append(res.Errors, FieldError{Field: name, Rule: rule, Message: "不能为空"})
+				// 没有值的时候，后面的规则没有意义
+				return nil
+			}
+			continue
+		}
+
+		val := fv
+		for val.Kind() == reflect.Pointer {
+			if val.IsNil() {
+				return nil
+			}
+			val = val.Elem()
+		}
+		ok, msg, err := checkRule(val, rule, param)
+		if err != nil {
+			return fmt.Errorf("web: 字段 %s 的校验规则 %q 错误: %w", name, rule, err)
+		}
+		if !ok {
+			res.Errors = append(res.Errors, FieldError{Field: name, Rule: rule, Param: param, Message: msg})
+		}
+	}
+	return nil
+}
+
+// checkRule 返回是否通过校验，以及没有通过时的提示信息
+func checkRule(val reflect.Value, rule string, param string) (bool, string, error) {
+	switch rule {
+	case "min", "max":
+		limit, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return false, "", err
+		}
+		n, isLen, err := measure(val)
+		if err != nil {
+			return false, "", err
+		}
+		desc := "值"
+		if isLen {
+			desc = "长度"
+		}
+		if rule == "min" && n < limit {
+			return false, fmt.Sprintf("%s不能小于%s", desc, param), nil
+		}
+		if rule == "max" && n > limit {
+			return false, fmt.Sprintf("%s不能大于%s", desc, param), nil
+		}
+		return true, "", nil
+	case "email":
+		if val.Kind() != reflect.String {
+			return false, "", errors.New("email只能用于字符串")
+		}
+		addr, err := mail.ParseAddress(val.String())
+		if err != nil || addr.Address != val.String() {
+			return false, "不是合法的邮箱地址", nil
+		}
+		return true, "", nil
+	case "oneof":
+		if param == "" {
+			return false, "", errors.New("oneof缺少候选值")
+		}
+		candidates := strings.Fields(param)
+		if !slices.Contains(candidates, fmt.Sprint(val)) {
+			return false, fmt.Sprintf("必须是[%s]其中之一", param), nil
+		}
+		return true, "", nil
+	}
+	return false, "", errors.New("不支持的校验规则")
+}
+
+// measure 数字返回值本身，字符串返回字符数，切片、数组和map返回长度
+func measure(val reflect.Value) (float64, bool, error) {
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(val.Int()), false, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(val.Uint()), false, nil
+	case reflect.Float32, reflect.Float64:
+		return val.Float(), false, nil
+	case reflect.String:
+		return float64(utf8.RuneCountInString(val.String())), true, nil
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(val.Len()), true, nil
+	}
+	return 0, false, fmt.Errorf("不支持比较 %s 类型", val.Type())
+}
+
+func isEmptyValue(fv reflect.Value) bool {
+	switch fv.Kind() {
+	case reflect.Slice, reflect.Map:
+		return fv.Len() == 0
+	}
+	return fv.IsZero()
+}
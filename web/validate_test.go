@@ -0,0 +1,137 @@
+package web
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type validateAddress struct {
+	City string `validate:"required"`
+}
+
+type validatePage struct {
+	Size int `query:"size" validate:"max=100"`
+}
+
+type validateUser struct {
+	validatePage
+	Name    string           `query:"name" validate:"required,min=2,max=4"`
+	Tags    []string         `query:"tag" validate:"required,max=2"`
+	Age     *int             `query:"age" validate:"min=1"`
+	Score   float64          `query:"score" validate:"oneof=60 100"`
+	Address *validateAddress `validate:"required"`
+	Home    validateAddress
+}
+
+func TestValidate(t *testing.T) {
+	age := 0
+	testCases := []struct {
+		name    string
+		val     any
+		wantErr []FieldError
+	}{
+		{
+			name: "ok",
+			val: &validateUser{
+				Name:    "汤姆",
+				Tags:    []string{"a"},
+				Score:   100,
+				Address: &validateAddress{City: "beijing"},
+				Home:    validateAddress{City: "shanghai"},
+			},
+		},
+		{
+			name: "not struct",
+			val:  map[string]string{},
+		},
+		{
+			name: "failed",
+			val: validateUser{
+				validatePage: validatePage{Size: 101},
+				Name:         "t",
+				Tags:         []string{"a", "b", "c"},
+				Age:          &age,
+				Score:        99,
+				Address:      &validateAddress{},
+			},
+			wantErr: []FieldError{
+				{Field: "Size", Rule: "max", Param: "100", Message: "值不能大于100"},
+				{Field: "Name", Rule: "min", Param: "2", Message: "长度不能小于2"},
+				{Field: "Tags", Rule: "max", Param: "2", Message: "长度不能大于2"},
+				{Field: "Age", Rule: "min", Param: "1", Message: "值不能小于1"},
+				{Field: "Score", Rule: "oneof", Param: "60 100", Message: "必须是[60 100]其中之一"},
+				{Field: "Address.City", Rule: "required", Message: "不能为空"},
+				{Field: "Home.City", Rule: "required", Message: "不能为空"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Validate(tc.val)
+			if tc.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			var validationErr *ValidationError
+			require.True(t, errors.As(err, &validationErr))
+			assert.Equal(t, tc.wantErr, validationErr.Errors)
+		})
+	}
+
+	type badRule struct {
+		Name string `validate:"unknown"`
+	}
+	err := Validate(badRule{Name: "tom"})
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, new(*ValidationError)))
+}
+
+func TestContext_BindValidate(t *testing.T) {
+	type loginReq struct {
+		Name string `query:"name" validate:"required"`
+	}
+	ctx := &Context{
+		Req: httptest.NewRequest(http.MethodGet, "/login", nil),
+	}
+	var req loginReq
+	err := ctx.Bind(&req)
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, err, ctx.Err)
+}
+
+func TestContext_BindPartialValidate(t *testing.T) {
+	type listReq struct {
+		ID   int64  `path:"id" validate:"required"`
+		Page int    `query:"page" validate:"required"`
+		Name string `json:"name" validate:"required"`
+	}
+	ctx := &Context{
+		Req:        httptest.NewRequest(http.MethodGet, "/user/12/orders?page=2", nil),
+		PathParams: map[string]string{"id": "12"},
+	}
+	var req listReq
+	// 只校验路径参数，Page还没有绑定
+	require.NoError(t, ctx.BindPath(&req))
+	require.NoError(t, ctx.BindQuery(&req))
+	assert.Equal(t, listReq{ID: 12, Page: 2}, req)
+
+	// 完整的校验包含没有绑定过的字段
+	err := ctx.Validate(&req)
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, []FieldError{{Field: "Name", Rule: "required", Message: "不能为空"}}, validationErr.Errors)
+	assert.Equal(t, err, ctx.Err)
+
+	// 绑定的字段没有通过校验
+	ctx = &Context{Req: httptest.NewRequest(http.MethodGet, "/user/12/orders", nil)}
+	err = ctx.BindQuery(&listReq{})
+	require.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, "Page", validationErr.Errors[0].Field)
+	assert.Len(t, validationErr.Errors, 1)
+}
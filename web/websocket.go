@@ -0,0 +1,536 @@
+package web
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// 消息类型，和RFC 6455中的opcode一致
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+
+	continuationFrame = 0
+)
+
+// 常用的关闭码
+const (
+	CloseNormalClosure      = 1000
+	CloseGoingAway          = 1001
+	CloseProtocolError      = 1002
+	CloseUnsupportedData    = 1003
+	CloseNoStatusReceived   = 1005
+	CloseInvalidPayloadData = 1007
+	CloseMessageTooBig      = 1009
+)
+
+const (
+	// 握手时计算Sec-WebSocket-Accept使用的固定GUID
+	webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	// 控制帧的负载不能超过125字节
+	maxControlPayload = 125
+	// 默认的单条消息大小限制
+	defaultReadLimit = 1 << 20
+	// 关闭握手时等待对方回复关闭帧的时间
+	closeHandshakeTimeout = 3 * time.Second
+)
+
+var (
+	errNotWebSocket     = errors.New("web: 不是合法的WebSocket握手请求")
+	errBadOrigin        = errors.New("web: WebSocket请求的Origin不被允许")
+	errHijackNotSupport = errors.New("web: ResponseWriter不支持Hijack，无法升级为WebSocket")
+	// ErrReadLimit 单条消息超过了读取限制
+	ErrReadLimit = errors.New("web: WebSocket消息超过了读取限制")
+	// ErrWebSocketClosed 连接已经发送过关闭帧
+	ErrWebSocketClosed = errors.New("web: WebSocket连接已关闭")
+)
+
+// CloseError 对方发送了关闭帧，或者因为协议错误关闭了连接
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("web: WebSocket连接关闭, code: %d, text: %s", e.Code, e.Text)
+}
+
+type WebSocketOption func(conn *WebSocketConn)
+
+// WebSocketWithCheckOrigin 校验请求的Origin，默认只允许和Host一致的Origin以及没有Origin的请求
+func WebSocketWithCheckOrigin(fn func(req *http.Request) bool) WebSocketOption {
+	return func(conn *WebSocketConn) {
+		conn.checkOrigin = fn
+	}
+}
+
+// WebSocketWithSubprotocols 服务端支持的子协议，按照客户端的顺序选择第一个服务端支持的
+func WebSocketWithSubprotocols(protocols ...string) WebSocketOption {
+	return func(conn *WebSocketConn) {
+		conn.supportedProtocols = protocols
+	}
+}
+
+// WebSocketWithReadLimit 单条消息（包括所有分片）的最大字节数，超过之后以1009关闭连接
+func WebSocketWithReadLimit(limit int64) WebSocketOption {
+	return func(conn *WebSocketConn) {
+		conn.readLimit = limit
+	}
+}
+
+// WebSocketWithFragmentSize 发送消息时每个分片的最大字节数，为0时不分片
+func WebSocketWithFragmentSize(size int) WebSocketOption {
+	return func(conn *WebSocketConn) {
+		conn.fragmentSize = size
+	}
+}
+
+// WebSocketConn 升级之后的WebSocket连接
+// 读操作只能在一个goroutine中进行，写操作可以并发调用
+type WebSocketConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	checkOrigin        func(req *http.Request) bool
+	supportedProtocols []string
+	subprotocol        string
+	readLimit          int64
+	fragmentSize       int
+
+	pingHandler func(data []byte) error
+	pongHandler func(data []byte) error
+
+	writeMutex sync.Mutex
+	closeSent  bool
+	// 是否已经收到了对方的关闭帧
+	closeReceived bool
+}
+
+// UpgradeWebSocket 完成RFC 6455的握手，通过http.Hijacker接管底层连接
+// 握手失败时会设置好RespStatusCode和RespData，handler直接返回即可
+// 升级成功之后响应已经由WebSocket连接接管，不再使用RespData
+func (c *Context) UpgradeWebSocket(opts ...WebSocketOption) (*WebSocketConn, error) {
+	ws := &WebSocketConn{
+		checkOrigin: checkSameOrigin,
+		readLimit:   defaultReadLimit,
+	}
+	for _, opt := range opts {
+		opt(ws)
+	}
+
+	req := c.Req
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if req.Method != http.MethodGet ||
+		!headerContainsToken(req.Header, "Connection", "upgrade") ||
+		!headerContainsToken(req.Header, "Upgrade", "websocket") ||
+		req.Header.Get("Sec-WebSocket-Version") != "13" || !isValidWebSocketKey(key) {
+		c.RespStatusCode = http.StatusBadRequest
+		c.RespData = []byte(errNotWebSocket.Error())
+		return nil, errNotWebSocket
+	}
+	if !ws.checkOrigin(req) {
+		c.RespStatusCode = http.StatusForbidden
+		c.RespData = []byte(errBadOrigin.Error())
+		return nil, errBadOrigin
+	}
+	hijacker, ok := c.Resp.(http.Hijacker)
+	if !ok {
+		c.RespStatusCode = http.StatusInternalServerError
+		return nil, errHijackNotSupport
+	}
+	ws.subprotocol = ws.selectSubprotocol(req)
+
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		c.RespStatusCode = http.StatusInternalServerError
+		return nil, err
+	}
+	// 从这里开始响应由WebSocket连接接管
+	c.hijacked = true
+	c.RespStatusCode = http.StatusSwitchingProtocols
+
+	var sb strings.Builder
+	sb.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	sb.WriteString("Upgrade: websocket\r\n")
+	sb.WriteString("Connection: Upgrade\r\n")
+	sb.WriteString("Sec-WebSocket-Accept: " + computeAcceptKey(key) + "\r\n")
+	if ws.subprotocol != "" {
+		sb.WriteString("Sec-WebSocket-Protocol: " + ws.subprotocol + "\r\n")
+	}
+	sb.WriteString("\r\n")
+	if _, err = brw.WriteString(sb.String()); err == nil {
+		err = brw.Flush()
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	// 握手之前设置的超时时间不再适用
+	_ = conn.SetDeadline(time.Time{})
+	ws.conn = conn
+	ws.br = brw.Reader
+	ws.pingHandler = func(data []byte) error {
+		return ws.WriteControl(PongMessage, data)
+	}
+	ws.pongHandler = func(data []byte) error {
+		return nil
+	}
+	return ws, nil
+}
+
+// Subprotocol 握手时协商出来的子协议
+func (w *WebSocketConn) Subprotocol() string {
+	return w.subprotocol
+}
+
+// SetPingHandler 收到ping时调用，默认回复一个同样负载的pong
+func (w *WebSocketConn) SetPingHandler(fn func(data []byte) error) {
+	w.pingHandler = fn
+}
+
+// SetPongHandler 收到pong时调用，一般用来延长读超时时间
+func (w *WebSocketConn) SetPongHandler(fn func(data []byte) error) {
+	w.pongHandler = fn
+}
+
+func (w *WebSocketConn) SetReadDeadline(t time.Time) error {
+	return w.conn.SetReadDeadline(t)
+}
+
+func (w *WebSocketConn) SetWriteDeadline(t time.Time) error {
+	return w.conn.SetWriteDeadline(t)
+}
+
+// ReadMessage 读取一条完整的数据消息，分片会被拼接起来，ping、pong和关闭帧在内部处理
+// 收到关闭帧时会回复关闭帧，并返回*CloseError
+func (w *WebSocketConn) ReadMessage() (int, []byte, error) {
+	var (
+		messageType int
+		message     []byte
+	)
+	for {
+		f, err := w.readFrame()
+		if err != nil {
+			return 0, nil, w.handleReadErr(err)
+		}
+		switch f.opcode {
+		case PingMessage:
+			if err = w.pingHandler(f.payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			if err = w.pongHandler(f.payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case CloseMessage:
+			return 0, nil, w.handleClose(f.payload)
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, w.protocolError("上一条消息的分片还没有结束")
+			}
+			messageType = int(f.opcode)
+		case continuationFrame:
+			if messageType == 0 {
+				return 0, nil, w.protocolError("没有起始帧的分片")
+			}
+		}
+		if int64(len(message))+int64(len(f.payload)) > w.readLimit {
+			_ = w.closeWithCode(CloseMessageTooBig, "")
+			return 0, nil, ErrReadLimit
+		}
+		message = append(message, f.payload...)
+		if !f.fin {
+			continue
+		}
+		if messageType == TextMessage && !utf8.Valid(message) {
+			_ = w.closeWithCode(CloseInvalidPayloadData, "")
+			return 0, nil, &CloseError{Code: CloseInvalidPayloadData, Text: "非法的UTF-8文本"}
+		}
+		return messageType, message, nil
+	}
+}
+
+// WriteMessage 发送一条文本或者二进制消息，设置了分片大小时会拆成多个分片发送
+func (w *WebSocketConn) WriteMessage(messageType int, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("web: 不支持的WebSocket消息类型 %d", messageType)
+	}
+	w.writeMutex.Lock()
+	defer w.writeMutex.Unlock()
+	if w.closeSent {
+		return ErrWebSocketClosed
+	}
+	if w.fragmentSize <= 0 || len(data) <= w.fragmentSize {
+		return w.writeFrame(true, byte(messageType), data)
+	}
+	opcode := byte(messageType)
+	for len(data) > w.fragmentSize {
+		if err := w.writeFrame(false, opcode, data[:w.fragmentSize]); err != nil {
+			return err
+		}
+		data = data[w.fragmentSize:]
+		opcode = continuationFrame
+	}
+	return w.writeFrame(true, opcode, data)
+}
+
+// WriteControl 发送ping、pong这种控制帧，负载不能超过125字节
+func (w *WebSocketConn) WriteControl(messageType int, data []byte) error {
+	if messageType != PingMessage && messageType != PongMessage && messageType != CloseMessage {
+		return fmt.Errorf("web: %d 不是控制帧", messageType)
+	}
+	if len(data) > maxControlPayload {
+		return errors.New("web: 控制帧的负载不能超过125字节")
+	}
+	w.writeMutex.Lock()
+	defer w.writeMutex.Unlock()
+	if w.closeSent {
+		return ErrWebSocketClosed
+	}
+	if messageType == CloseMessage {
+		w.closeSent = true
+	}
+	return w.writeFrame(true, byte(messageType), data)
+}
+
+// Close 发起关闭握手，发送关闭帧之后等待对方回复关闭帧，然后关闭底层连接
+// Close不能和ReadMessage并发调用
+func (w *WebSocketConn) Close(code int, text string) error {
+	err := w.closeWithCode(code, text)
+	if err == nil && !w.closeReceived {
+		_ = w.conn.SetReadDeadline(time.Now().Add(closeHandshakeTimeout))
+		for {
+			f, readErr := w.readFrame()
+			if readErr != nil || f.opcode == CloseMessage {
+				break
+			}
+		}
+	}
+	if closeErr := w.conn.Close(); err == nil || errors.Is(err, ErrWebSocketClosed) {
+		err = closeErr
+	}
+	return err
+}
+
+func (w *WebSocketConn) closeWithCode(code int, text string) error {
+	payload := make([]byte, 2, 2+len(text))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, text...)
+	if len(payload) > maxControlPayload {
+		payload = payload[:maxControlPayload]
+	}
+	return w.WriteControl(CloseMessage, payload)
+}
+
+// handleClose 收到对方的关闭帧，回复同样的关闭码
+func (w *WebSocketConn) handleClose(payload []byte) error {
+	w.closeReceived = true
+	closeErr := &CloseError{Code: CloseNoStatusReceived}
+	if len(payload) == 1 {
+		closeErr = &CloseError{Code: CloseProtocolError, Text: "非法的关闭帧"}
+	} else if len(payload) >= 2 {
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Text = string(payload[2:])
+	}
+	reply := closeErr.Code
+	if reply == CloseNoStatusReceived {
+		reply = CloseNormalClosure
+	}
+	err := w.closeWithCode(reply, "")
+	if err != nil && !errors.Is(err, ErrWebSocketClosed) {
+		return err
+	}
+	return closeErr
+}
+
+// handleReadErr 协议错误和超过读取限制时，发送对应的关闭帧
+func (w *WebSocketConn) handleReadErr(err error) error {
+	var closeErr *CloseError
+	if errors.As(err, &closeErr) {
+		_ = w.closeWithCode(closeErr.Code, "")
+	}
+	if errors.Is(err, ErrReadLimit) {
+		_ = w.closeWithCode(CloseMessageTooBig, "")
+	}
+	return err
+}
+
+func (w *WebSocketConn) protocolError(msg string) error {
+	_ = w.closeWithCode(CloseProtocolError, "")
+	return &CloseError{Code: CloseProtocolError, Text: msg}
+}
+
+type wsFrame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+// readFrame 读取一帧，客户端发送的帧必须带掩码
+func (w *WebSocketConn) readFrame() (*wsFrame, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(w.br, header[:]); err != nil {
+		return nil, err
+	}
+	f := &wsFrame{
+		fin:    header[0]&0x80 != 0,
+		opcode: header[0] & 0x0f,
+	}
+	// 没有协商任何扩展，RSV必须为0
+	if header[0]&0x70 != 0 {
+		return nil, &CloseError{Code: CloseProtocolError, Text: "RSV必须为0"}
+	}
+	switch f.opcode {
+	case continuationFrame, TextMessage, BinaryMessage:
+	case CloseMessage, PingMessage, PongMessage:
+		if !f.fin {
+			return nil, &CloseError{Code: CloseProtocolError, Text: "控制帧不能分片"}
+		}
+	default:
+		return nil, &CloseError{Code: CloseProtocolError, Text: "未知的opcode"}
+	}
+	if header[1]&0x80 == 0 {
+		return nil, &CloseError{Code: CloseProtocolError, Text: "客户端的帧必须带掩码"}
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(w.br, ext[:]); err != nil {
+			return nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(w.br, ext[:]); err != nil {
+			return nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if f.opcode >= CloseMessage && length > maxControlPayload {
+		return nil, &CloseError{Code: CloseProtocolError, Text: "控制帧的负载不能超过125字节"}
+	}
+	// 先检查长度再分配内存，避免恶意的超大长度
+	if length > uint64(w.readLimit) {
+		return nil, ErrReadLimit
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(w.br, mask[:]); err != nil {
+		return nil, err
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(w.br, f.payload); err != nil {
+		return nil, err
+	}
+	for i := range f.payload {
+		f.payload[i] ^= mask[i%4]
+	}
+	return f, nil
+}
+
+// writeFrame 服务端发送的帧不带掩码，调用方需要持有writeMutex
+func (w *WebSocketConn) writeFrame(fin bool, opcode byte, payload []byte) error {
+	header := make([]byte, 0, 10)
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	header = append(header, b0)
+	length := len(payload)
+	switch {
+	case length <= 125:
+		header = append(header, byte(length))
+	case length <= 0xffff:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+	buffers := net.Buffers{header, payload}
+	_, err := buffers.WriteTo(w.conn)
+	return err
+}
+
+func (w *WebSocketConn) selectSubprotocol(req *http.Request) string {
+	if len(w.supportedProtocols) == 0 {
+		return ""
+	}
+	for _, requested := range headerTokens(req.Header, "Sec-WebSocket-Protocol") {
+		for _, supported := range w.supportedProtocols {
+			if requested == supported {
+				return supported
+			}
+		}
+	}
+	return ""
+}
+
+func computeAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// isValidWebSocketKey Sec-WebSocket-Key必须是16字节随机数的base64编码
+func isValidWebSocketKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	return err == nil && len(decoded) == 16
+}
+
+// checkSameOrigin 没有Origin的请求一般不是浏览器发起的，直接放行
+func checkSameOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, req.Host)
+}
+
+// headerTokens 解析逗号分隔的请求头，例如 Connection: keep-alive, Upgrade
+func headerTokens(header http.Header, name string) []string {
+	var res []string
+	for _, val := range header.Values(name) {
+		for _, token := range strings.Split(val, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				res = append(res, token)
+			}
+		}
+	}
+	return res
+}
+
+func headerContainsToken(header http.Header, name string, token string) bool {
+	for _, t := range headerTokens(header, name) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+package web
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestComputeAcceptKey(t *testing.T) {
+	// RFC 6455 中的例子
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", computeAcceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestContext_UpgradeWebSocket(t *testing.T) {
+	// 升级之后middleware依旧在链条上
+	statuses := make(chan int, 4)
+	server := NewHTTPServer(ServerWithMiddleware(func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			next(ctx)
+			statuses <- ctx.RespStatusCode
+		}
+	}))
+	serverErr := make(chan error, 1)
+	group := server.Group("/ws")
+	group.GET("/echo", func(ctx *Context) {
+		conn, err := ctx.UpgradeWebSocket(WebSocketWithSubprotocols("chat"),
+			WebSocketWithReadLimit(64), WebSocketWithFragmentSize(4))
+		if err != nil {
+			return
+		}
+		for {
+			typ, data, err := conn.ReadMessage()
+			if err != nil {
+				serverErr <- err
+				_ = conn.Close(CloseNormalClosure, "")
+				return
+			}
+			if err = conn.WriteMessage(typ, data); err != nil {
+				serverErr <- err
+				return
+			}
+		}
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	// 普通的请求不能升级
+	resp, err := http.Get(httpServer.URL + "/ws/echo")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, <-statuses)
+
+	client := dialWebSocket(t, httpServer.Listener.Addr().String(), "/ws/echo")
+	assert.Equal(t, "chat", client.subprotocol)
+
+	// 分片发送的消息被拼接起来，回复时按照4字节分片
+	client.writeFrame(t, false, TextMessage, []byte("hello, "))
+	client.writeFrame(t, true, PingMessage, []byte("ping"))
+	client.writeFrame(t, true, continuationFrame, []byte("world"))
+	fin, opcode, payload := client.readFrame(t)
+	assert.Equal(t, []any{true, byte(PongMessage), "ping"}, []any{fin, opcode, string(payload)})
+	var message []byte
+	for {
+		fin, opcode, payload = client.readFrame(t)
+		message = append(message, payload...)
+		if fin {
+			break
+		}
+	}
+	assert.Equal(t, "hello, world", string(message))
+
+	client.writeFrame(t, true, BinaryMessage, []byte{1, 2, 3})
+	_, opcode, payload = client.readFrame(t)
+	assert.Equal(t, byte(BinaryMessage), opcode)
+	assert.Equal(t, []byte{1, 2, 3}, payload)
+
+	// 关闭握手
+	closePayload := binary.BigEndian.AppendUint16(nil, CloseGoingAway)
+	client.writeFrame(t, true, CloseMessage, append(closePayload, "bye"...))
+	_, opcode, payload = client.readFrame(t)
+	assert.Equal(t, byte(CloseMessage), opcode)
+	assert.Equal(t, uint16(CloseGoingAway), binary.BigEndian.Uint16(payload))
+	var closeErr *CloseError
+	require.True(t, errors.As(<-serverErr, &closeErr))
+	assert.Equal(t, &CloseError{Code: CloseGoingAway, Text: "bye"}, closeErr)
+	_ = client.conn.Close()
+	assert.Equal(t, http.StatusSwitchingProtocols, <-statuses)
+	httpServer.Close()
+
+	// 超过读取限制
+	httpServer = httptest.NewServer(server)
+	client = dialWebSocket(t, httpServer.Listener.Addr().String(), "/ws/echo")
+	client.writeFrame(t, true, TextMessage, []byte(strings.Repeat("a", 65)))
+	_, opcode, payload = client.readFrame(t)
+	assert.Equal(t, byte(CloseMessage), opcode)
+	assert.Equal(t, uint16(CloseMessageTooBig), binary.BigEndian.Uint16(payload))
+	assert.Equal(t, ErrReadLimit, <-serverErr)
+	_ = client.conn.Close()
+	httpServer.Close()
+}
+
+type testWebSocketClient struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	subprotocol string
+}
+
+func dialWebSocket(t *testing.T, addr string, path string) *testWebSocketClient {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("GET " + path + " HTTP/1.1\r\n" +
+		"Host: " + addr + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Protocol: json, chat\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"))
+	require.NoError(t, err)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	require.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+	return &testWebSocketClient{
+		conn:        conn,
+		br:          br,
+		subprotocol: resp.Header.Get("Sec-WebSocket-Protocol"),
+	}
+}
+
+// writeFrame 客户端发送的帧必须带掩码
+func (c *testWebSocketClient) writeFrame(t *testing.T, fin bool, opcode byte, payload []byte) {
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0}
+	if len(payload) <= 125 {
+		frame = append(frame, 0x80|byte(len(payload)))
+	} else {
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := c.conn.Write(frame)
+	require.NoError(t, err)
+}
+
+func (c *testWebSocketClient) readFrame(t *testing.T) (bool, byte, []byte) {
+	var header [2]byte
+	_, err := io.ReadFull(c.br, header[:])
+	require.NoError(t, err)
+	length := int(header[1] & 0x7f)
+	if length == 126 {
+		var ext [2]byte
+		_, err = io.ReadFull(c.br, ext[:])
+		require.NoError(t, err)
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(c.br, payload)
+	require.NoError(t, err)
+	return header[0]&0x80 != 0, header[0] & 0x0f, payload
+}